@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"fmt"
+
+	"ricketyspace.net/fern/config"
+)
+
+var cmdConfig = &command{
+	name:  "config",
+	args:  "<command> [arguments]",
+	short: "Manage the config",
+	commands: []*command{
+		cmdConfigCheck,
+	},
+}
+
+var cmdConfigCheck = &command{
+	name:  "check",
+	short: "Validate the config",
+	long: `Check validates the config without changing anything: directories
+named in it are not created. It reports all problems found, with the
+line and column in the config and the index and id of the feed they
+are in, and exits with status 1 if there are any. Warnings, like
+unknown keys, are reported too; they do not stop other commands from
+using the config.`,
+	run: runConfigCheck,
+}
+
+func runConfigCheck(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	if _, ok, code := cmd.parse(fs, args, 0, 0); !ok {
+		return code
+	}
+
+	p, err := config.Path(configFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	problems := config.Check(p)
+	for _, problem := range problems {
+		if problem.Warning {
+			fmt.Printf("Warning: %v\n", problem)
+			continue
+		}
+		fmt.Printf("%v\n", problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("Config is not valid: found %s\n",
+			entriesTxt(len(problems), "problem", "problems"))
+		return 1
+	}
+	fmt.Printf("Config is valid\n")
+	return 0
+}
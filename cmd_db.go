@@ -0,0 +1,224 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ricketyspace.net/fern/db"
+)
+
+var cmdDB = &command{
+	name:  "db",
+	args:  "<command> [arguments]",
+	short: "Manage the database of downloaded entries",
+	commands: []*command{
+		cmdDBList,
+		cmdDBForget,
+		cmdDBMark,
+		cmdDBPrune,
+	},
+}
+
+var cmdDBList = &command{
+	name:  "list",
+	args:  "[-wait] [feed]",
+	short: "List the entries in the database",
+	long: `List lists the entries downloaded for the feed, or for all feeds in
+the database if no feed is given.`,
+	run: runDBList,
+}
+
+func runDBList(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	wFlag := fs.Bool("wait", false,
+		"Wait for another running fern to finish instead of failing")
+	pos, ok, code := cmd.parse(fs, args, 0, 1)
+	if !ok {
+		return code
+	}
+
+	// Open database.
+	fdb, err := openDB(nil, *wFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	defer fdb.Close()
+
+	feeds := fdb.Feeds()
+	if len(pos) > 0 {
+		feeds = pos
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "FEED\tENTRY\tDOWNLOADED AT\tTITLE\n")
+	for _, feed := range feeds {
+		for _, r := range fdb.Records(feed) {
+			at := "-"
+			if !r.DownloadedAt.IsZero() {
+				at = r.DownloadedAt.Local().Format(time.RFC3339)
+			}
+			title := r.Title
+			if len(title) == 0 {
+				title = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", feed, r.EntryId, at,
+				title)
+		}
+	}
+	tw.Flush()
+	return 0
+}
+
+var cmdDBForget = &command{
+	name:  "forget",
+	args:  "[-wait] feed entry...",
+	short: "Remove entries from the database",
+	long: `Forget removes the entries of the feed from the database, so that
+'fern run' downloads them again if they are still in the feed.`,
+	run: runDBForget,
+}
+
+func runDBForget(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	wFlag := fs.Bool("wait", false,
+		"Wait for another running fern to finish instead of failing")
+	pos, ok, code := cmd.parse(fs, args, 2, -1)
+	if !ok {
+		return code
+	}
+
+	// Open database.
+	fdb, err := openDB(nil, *wFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	defer fdb.Close()
+
+	feed := pos[0]
+	code = 0
+	for _, entry := range pos[1:] {
+		ok, err := fdb.Forget(feed, entry)
+		if err != nil {
+			fmt.Printf("Error: unable to journal: %v\n", err.Error())
+		}
+		if !ok {
+			fmt.Printf("[%s][%s]: Not in the database\n", feed, entry)
+			code = 1
+			continue
+		}
+		fmt.Printf("[%s][%s]: Forgotten\n", feed, entry)
+	}
+	return writeDB(fdb, code)
+}
+
+var cmdDBMark = &command{
+	name:  "mark",
+	args:  "[-wait] feed entry...",
+	short: "Mark entries as downloaded",
+	long: `Mark adds the entries of the feed to the database as seen, so that
+'fern run' does not download them.`,
+	run: runDBMark,
+}
+
+func runDBMark(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	wFlag := fs.Bool("wait", false,
+		"Wait for another running fern to finish instead of failing")
+	pos, ok, code := cmd.parse(fs, args, 2, -1)
+	if !ok {
+		return code
+	}
+
+	// Open database.
+	fdb, err := openDB(nil, *wFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	defer fdb.Close()
+
+	feed := pos[0]
+	for _, entry := range pos[1:] {
+		if fdb.Exists(feed, entry) {
+			fmt.Printf("[%s][%s]: Already in the database\n", feed,
+				entry)
+			continue
+		}
+		err := fdb.AddRecord(feed, db.Record{
+			EntryId:  entry,
+			Baseline: true,
+		})
+		if err != nil {
+			fmt.Printf("Error: unable to journal: %v\n", err.Error())
+		}
+		fmt.Printf("[%s][%s]: Marked\n", feed, entry)
+	}
+	return writeDB(fdb, 0)
+}
+
+var cmdDBPrune = &command{
+	name:  "prune",
+	args:  "[-wait]",
+	short: "Remove feeds that are not in the config from the database",
+	run:   runDBPrune,
+}
+
+func runDBPrune(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	wFlag := fs.Bool("wait", false,
+		"Wait for another running fern to finish instead of failing")
+	if _, ok, code := cmd.parse(fs, args, 0, 0); !ok {
+		return code
+	}
+
+	// Get fern config.
+	fConf, err := inspectConfig()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	// Open database.
+	fdb, err := openDB(fConf, *wFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	defer fdb.Close()
+
+	keep := []string{}
+	for _, f := range fConf.Feeds {
+		keep = append(keep, f.Id)
+	}
+	pruned, err := fdb.Prune(keep)
+	if err != nil {
+		fmt.Printf("Error: unable to journal: %v\n", err.Error())
+	}
+	for _, feed := range pruned {
+		fmt.Printf("[%s]: Removed\n", feed)
+	}
+	fmt.Printf("Removed %s\n", entriesTxt(len(pruned), "feed", "feeds"))
+	return writeDB(fdb, 0)
+}
+
+// Writes `fdb` to disk.
+//
+// Returns `code` if the write succeeds; 1 otherwise.
+func writeDB(fdb *db.FernDB, code int) int {
+	err := fdb.Write()
+	if err != nil {
+		fmt.Printf("Error: unable to write db: %v\n", err.Error())
+		return 1
+	}
+	return code
+}
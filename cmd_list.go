@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var cmdList = &command{
+	name:  "list",
+	args:  "[-tag TAG]... [feed]...",
+	short: "List the feeds in the config",
+	long: `List lists the feeds in the config. If feeds are given, by id or
+by a glob pattern like 'npr-*', or tags are given, only the feeds that
+match one of them are listed.`,
+	run: runList,
+}
+
+func runList(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	tags := stringsFlag{}
+	fs.Var(&tags, "tag", "List the feeds tagged `TAG`; may be repeated")
+	patterns, ok, code := cmd.parse(fs, args, 0, -1)
+	if !ok {
+		return code
+	}
+
+	// Get fern config.
+	fConf, err := inspectConfig()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	feeds, err := fConf.Select(patterns, tags)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tSCHEMA\tDOWNLOADER\tLAST\tTAGS\tSOURCE\n")
+	for _, f := range feeds {
+		tagsTxt := strings.Join(f.Tags, ",")
+		if len(tagsTxt) == 0 {
+			tagsTxt = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", f.Id, f.Schema,
+			f.DownloaderName(), f.Last, tagsTxt, f.Source)
+	}
+	tw.Flush()
+	return 0
+}
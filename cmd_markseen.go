@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var cmdMarkSeen = &command{
+	name:  "mark-seen",
+	args:  "[-wait] feed...",
+	short: "Mark the entries in feeds as seen without downloading them",
+	long: `Mark-seen fetches the given feeds, by id or by a glob pattern like
+'npr-*', and records all of their entries in the database as seen
+without downloading them; 'fern run' downloads only the entries that
+are published after that.`,
+	run: runMarkSeen,
+}
+
+func runMarkSeen(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	wFlag := fs.Bool("wait", false,
+		"Wait for another running fern to finish instead of failing")
+	patterns, ok, code := cmd.parse(fs, args, 1, -1)
+	if !ok {
+		return code
+	}
+
+	// Get fern config.
+	fConf, err := readConfig()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	feeds, err := fConf.Select(patterns, nil)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	// Open database.
+	fdb, err := openDB(fConf, *wFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	defer fdb.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	code = 0
+	for _, f := range feeds {
+		n, err := f.MarkSeen(ctx, fdb)
+		if err != nil {
+			fmt.Printf("[%s]: Unable to mark entries as seen: %v\n",
+				f.Id, err.Error())
+			code = 1
+		}
+		if n > 0 || err == nil {
+			fmt.Printf("[%s]: Marked %s as seen\n", f.Id,
+				entriesTxt(n, "entry", "entries"))
+		}
+	}
+	err = fdb.Write()
+	if err != nil {
+		fmt.Printf("Error: unable to write db: %v\n", err.Error())
+		return 1
+	}
+	return code
+}
@@ -0,0 +1,362 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+	"time"
+
+	"ricketyspace.net/fern/feed"
+	"ricketyspace.net/fern/state"
+)
+
+// How long downloads in progress are allowed to take to finish after
+// fern is asked to shut down.
+const shutdownTimeout = time.Minute
+
+// How long fern waits for cancelled downloads to stop before exiting,
+// when it is asked to shut down a second time.
+const exitTimeout = 5 * time.Second
+
+var cmdRun = &command{
+	name: "run",
+	args: "[-wait] [-retry-failed] [-dry-run [-json]] [-prof DIR]" +
+		" [-tag TAG]... [feed]...",
+	short: "Download new entries in the feeds",
+	long: `Run fetches the feeds in the config and downloads the entries that
+were not downloaded before.
+
+If feeds are given, by id or by a glob pattern like 'npr-*', or tags
+are given, only the feeds that match one of them are run.
+
+With -dry-run, run fetches the feeds and shows which entries would be
+downloaded, which were downloaded before and which would be skipped,
+without downloading any of them or changing the database.
+
+Press Ctrl-C or send fern SIGTERM to stop it; fern does not start new
+downloads after that and exits once the downloads in progress finish.
+Press Ctrl-C again to stop the downloads in progress and exit right
+away.`,
+	run: runRun,
+}
+
+// Flags of the run command.
+type runFlags struct {
+	wait        bool
+	retryFailed bool
+	prof        string
+	dryRun      bool
+	json        bool
+	tags        stringsFlag
+}
+
+// Adds the flags of the run command, including the -config and -db
+// flags, to `fs`.
+//
+// Returns the flags' values, which are set when `fs` is parsed.
+func newRunFlags(fs *flag.FlagSet) *runFlags {
+	f := new(runFlags)
+	locationFlags(fs)
+	fs.BoolVar(&f.wait, "wait", false,
+		"Wait for another running fern to finish instead of failing")
+	fs.BoolVar(&f.retryFailed, "retry-failed", false,
+		"Retry entries quarantined after failing repeatedly")
+	fs.StringVar(&f.prof, "prof", "",
+		"Write cpu and memory profiles to the specified directory")
+	fs.BoolVar(&f.dryRun, "dry-run", false,
+		"Show what would be downloaded without downloading it")
+	fs.BoolVar(&f.json, "json", false, "With -dry-run, print JSON")
+	fs.Var(&f.tags, "tag", "Run the feeds tagged `TAG`; may be repeated")
+	return f
+}
+
+func runRun(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	flags := newRunFlags(fs)
+	patterns, ok, code := cmd.parse(fs, args, 0, -1)
+	if !ok {
+		return code
+	}
+
+	if flags.json && !flags.dryRun {
+		fmt.Printf("%s: -json needs -dry-run\n", cmd.path())
+		return 2
+	}
+
+	// Setup CPU and memory profiling if enabled.
+	if flags.prof != "" {
+		profileSuffix := fmt.Sprintf("%d.prof", time.Now().UnixMilli())
+
+		// CPU profiling.
+		cn := path.Join(flags.prof, "cpu."+profileSuffix)
+		cf, err := os.Create(cn)
+		if err != nil {
+			log.Fatal("could not create CPU profile: ", err)
+		}
+		defer cf.Close()
+		if err := pprof.StartCPUProfile(cf); err != nil {
+			log.Fatal("could not start CPU profile: ", err)
+		}
+		defer pprof.StopCPUProfile()
+
+		// Memory profiling.
+		mn := path.Join(flags.prof, "mem."+profileSuffix)
+		mf, err := os.Create(mn)
+		if err != nil {
+			log.Fatal("could not create memory profile: ", err)
+		}
+		defer mf.Close()
+		defer func() {
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(mf); err != nil {
+				log.Fatal("could not write memory profile: ", err)
+			}
+		}()
+		log.Printf("Profiling enabled. CPU and memory profiles"+
+			" will be written to %s and %s", cn, mn)
+	}
+
+	// Get fern config.
+	fConf, err := readConfig()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	feeds, err := fConf.Select(patterns, flags.tags)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	// Initialize process state.
+	pState := state.NewProcessState()
+	pState.RetryFailed = flags.retryFailed
+	pState.Limiter = state.NewLimiter(fConf.Limits())
+
+	// Open database.
+	pState.DB, err = openDB(fConf, flags.wait)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	// Release database lock before returning.
+	defer pState.DB.Close()
+
+	if flags.dryRun {
+		return dryRun(feeds, pState, flags.json)
+	}
+
+	// Write database to disk before returning. Entries downloaded
+	// until then are in the database's journal, in case fern
+	// does not get to return.
+	defer func() {
+		err := pState.DB.Write()
+		if err != nil {
+			fmt.Printf("Error: unable to write db: %v\n", err.Error())
+		}
+	}()
+
+	// Stop processing feeds on SIGINT or SIGTERM.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(pState, cancel)
+
+	// Process selected feeds.
+	processing := 0
+	for _, feed := range feeds {
+		f := feed
+		go f.Process(ctx, pState)
+		processing += 1
+	}
+	// Wait for all feeds finish processing.
+	for processing > 0 {
+		fTxt := "feeds"
+		if processing == 1 {
+			fTxt = "feed"
+		}
+		fmt.Printf("Waiting for %d %s to finish processing\n",
+			processing, fTxt)
+		fr := <-pState.FeedResultChan
+		if fr.Err == nil {
+			fmt.Printf("[%s]: %s\n",
+				fr.FeedId, fr.FeedResult)
+		} else {
+			fmt.Printf("[%s]: %s: %v\n",
+				fr.FeedId, fr.FeedResult, fr.Err.Error())
+		}
+		processing -= 1
+	}
+	return 0
+}
+
+// Handles SIGINT and SIGTERM.
+//
+// On the first signal, fern stops starting new downloads and waits
+// for the ones in progress to finish; if they do not finish within
+// shutdownTimeout, `cancel` is called to stop them. On the second
+// signal, `cancel` is called right away and fern exits shortly after;
+// what was downloaded until then is in the database's journal.
+func handleSignals(pState *state.ProcessState, cancel context.CancelFunc) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+
+	<-sigc
+	fmt.Printf("Shutting down after downloads in progress finish." +
+		" Interrupt again to exit right away\n")
+	pState.StartShutdown()
+	time.AfterFunc(shutdownTimeout, cancel)
+
+	<-sigc
+	fmt.Printf("Exiting\n")
+	cancel()
+	time.AfterFunc(exitTimeout, func() {
+		pState.DB.Close()
+		os.Exit(1)
+	})
+}
+
+// Dry run result of a feed.
+type dryRunFeed struct {
+	Id      string        `json:"id"`
+	Err     string        `json:"error,omitempty"`
+	Entries []dryRunEntry `json:"entries"`
+	plan    []feed.PlannedEntry
+	feed    *feed.Feed
+}
+
+// Dry run result of an entry.
+type dryRunEntry struct {
+	Id      string      `json:"id"`
+	Title   string      `json:"title"`
+	PubTime time.Time   `json:"pub-time"`
+	Link    string      `json:"link"`
+	Action  feed.Action `json:"action"`
+	Reason  string      `json:"reason,omitempty"`
+}
+
+// Gets the `feeds` and prints what would be done with their entries,
+// as text or, if `asJSON` is true, as JSON. Nothing is downloaded and
+// the database is not changed.
+//
+// Returns the code fern must exit with.
+func dryRun(feeds []feed.Feed, pState *state.ProcessState, asJSON bool) int {
+	ctx, cancel := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Plan all feeds.
+	results := make([]dryRunFeed, len(feeds))
+	done := make(chan int)
+	for i := range feeds {
+		go func(i int) {
+			f := &feeds[i]
+			r := dryRunFeed{Id: f.Id, Entries: []dryRunEntry{}, feed: f}
+			release, ok := pState.Limiter.AcquireFeed(ctx.Done())
+			if !ok {
+				r.Err = ctx.Err().Error()
+				results[i] = r
+				done <- i
+				return
+			}
+			plan, err := f.Plan(ctx, pState.DB, pState.RetryFailed)
+			release()
+			if err != nil {
+				r.Err = err.Error()
+			}
+			r.plan = plan
+			for _, pe := range plan {
+				r.Entries = append(r.Entries, dryRunEntry{
+					Id:      pe.Entry.Id,
+					Title:   pe.Entry.Title,
+					PubTime: pe.Entry.PubTime,
+					Link:    pe.Entry.Link,
+					Action:  pe.Action,
+					Reason:  pe.Reason,
+				})
+			}
+			results[i] = r
+			done <- i
+		}(i)
+	}
+	for range feeds {
+		<-done
+	}
+
+	failed := false
+	for _, r := range results {
+		if len(r.Err) > 0 {
+			failed = true
+		}
+	}
+	code := 0
+	if failed {
+		code = 1
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(results)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err.Error())
+			return 1
+		}
+		return code
+	}
+	for _, r := range results {
+		if len(r.Err) > 0 {
+			fmt.Printf("[%s]: Unable to get feed: %s\n", r.Id, r.Err)
+			continue
+		}
+		older := 0
+		download := 0
+		for _, pe := range r.plan {
+			e := pe.Entry
+			switch {
+			case pe.Action == feed.Download:
+				fmt.Printf("[%s][%s]: Would download '%s'\n", r.Id,
+					e.Id, e.Title)
+				download += 1
+			case pe.Action == feed.Present:
+				fmt.Printf("[%s][%s]: Already downloaded '%s' before\n",
+					r.Id, e.Id, e.Title)
+			case pe.Reason == feed.SkipTitle:
+				fmt.Printf("[%s][%s]: Would skip '%s'; title does not"+
+					" contain '%s'\n", r.Id, e.Id, e.Title,
+					r.feed.TitleContains)
+			case pe.Reason == feed.SkipQuarantined:
+				fmt.Printf("[%s][%s]: Would skip '%s' until %s; it"+
+					" failed to download %d times\n", r.Id, e.Id,
+					e.Title, r.feed.QuarantinedUntil(pe.Failure).
+						Local().Format(time.RFC3339),
+					pe.Failure.Count)
+			case pe.Reason == feed.SkipBaseline:
+				fmt.Printf("[%s][%s]: Would mark '%s' as seen without"+
+					" downloading it\n", r.Id, e.Id, e.Title)
+			case pe.Reason == feed.SkipLast:
+				older += 1
+			}
+		}
+		if older > 0 {
+			fmt.Printf("[%s]: Would skip %s beyond the last %d\n",
+				r.Id, entriesTxt(older, "older entry", "older entries"),
+				r.feed.Last)
+		}
+		fmt.Printf("[%s]: Would download %s\n", r.Id,
+			entriesTxt(download, "entry", "entries"))
+	}
+	return code
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var cmdStatus = &command{
+	name:  "status",
+	args:  "[-wait]",
+	short: "Show what was downloaded for each feed",
+	long: `Status shows, for each feed in the config, the number of entries
+downloaded, when the last one was downloaded, and the number of
+entries that are failing to download and that are quarantined.`,
+	run: runStatus,
+}
+
+func runStatus(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	locationFlags(fs)
+	wFlag := fs.Bool("wait", false,
+		"Wait for another running fern to finish instead of failing")
+	if _, ok, code := cmd.parse(fs, args, 0, 0); !ok {
+		return code
+	}
+
+	// Get fern config.
+	fConf, err := inspectConfig()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+
+	// Open database.
+	fdb, err := openDB(fConf, *wFlag)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	defer fdb.Close()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\tDOWNLOADED\tLAST DOWNLOAD\tFAILING\tQUARANTINED\n")
+	for _, f := range fConf.Feeds {
+		records := fdb.Records(f.Id)
+		last := time.Time{}
+		for _, r := range records {
+			if r.DownloadedAt.After(last) {
+				last = r.DownloadedAt
+			}
+		}
+		lastTxt := "-"
+		if !last.IsZero() {
+			lastTxt = last.Local().Format(time.RFC3339)
+		}
+		failures := fdb.Failures(f.Id)
+		quarantined := 0
+		for _, failure := range failures {
+			if f.Quarantined(failure) {
+				quarantined += 1
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\n", f.Id, len(records),
+			lastTxt, len(failures), quarantined)
+	}
+	tw.Flush()
+	return 0
+}
@@ -0,0 +1,545 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"ricketyspace.net/fern/feed"
+)
+
+// A problem found in the config by Check.
+type Problem struct {
+	Path   string // Path to the config
+	Line   int    // Line of the problem in the config; 0 if unknown
+	Column int    // Column of the problem in the config; 0 if unknown
+	Feed   int    // Index of the feed with the problem; -1 if none
+	FeedId string // Id of the feed with the problem
+	Err    error
+
+	// Whether the problem is only a warning, like an unknown key:
+	// the config can still be used.
+	Warning bool
+}
+
+func (p *Problem) Error() string {
+	msg := p.Path
+	if p.Line > 0 {
+		msg += fmt.Sprintf(":%d:%d", p.Line, p.Column)
+	}
+	msg += ": "
+	if p.Feed >= 0 {
+		msg += fmt.Sprintf("feeds[%d]", p.Feed)
+		if len(p.FeedId) > 0 {
+			msg += fmt.Sprintf(" '%s'", p.FeedId)
+		}
+		msg += ": "
+	}
+	return msg + p.Err.Error()
+}
+
+func (p *Problem) Unwrap() error {
+	return p.Err
+}
+
+// Checks the fern config at `p`, see Path, without side effects: it is
+// not changed and directories it names are not created.
+//
+// Returns all problems found in the config, errors and warnings,
+// ordered by their position in the config; nil if there are none.
+func Check(p string) []*Problem {
+	_, problems := check(p)
+	return problems
+}
+
+// Reads the fern config at `p` and checks it; see Check.
+//
+// Returns the config and the problems found in it. The config is nil
+// if it could not be read.
+func check(p string) (*FernConfig, []*Problem) {
+	c := checker{
+		path:     p,
+		offsets:  make(map[string]int64),
+		mistyped: make(map[string]bool),
+	}
+
+	bs, err := os.ReadFile(p)
+	if err != nil {
+		c.add("", -1, err)
+		return nil, c.problems
+	}
+	c.bs = bs
+
+	// Check syntax.
+	err = json.Unmarshal(bs, new(any))
+	if err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			// The offset is just past the invalid byte.
+			c.addAt(max(se.Offset-1, 0), -1, err)
+		} else {
+			c.add("", -1, err)
+		}
+		return nil, c.problems
+	}
+
+	// Check keys.
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	c.scan(dec, "", reflect.TypeOf(FernConfig{}))
+
+	// Check values. Values of the wrong type were found by scan.
+	config, err := parse(p, bs)
+	var te *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &te) {
+		c.add("", -1, err)
+		return nil, c.sorted()
+	}
+	if te != nil && len(c.mistyped) == 0 {
+		// Like a fraction where an integer is expected.
+		c.addAt(te.Offset, -1, fmt.Errorf("'%s' must be %s, not %s",
+			te.Field, typeName(te.Type), te.Value))
+	}
+	config.check(&c)
+
+	// Name the feed each problem is in.
+	for _, problem := range c.problems {
+		if problem.Feed >= 0 && problem.Feed < len(config.Feeds) {
+			problem.FeedId = config.Feeds[problem.Feed].Id
+		}
+	}
+	return config, c.sorted()
+}
+
+// Collects the problems found in a config.
+type checker struct {
+	path     string
+	bs       []byte
+	offsets  map[string]int64 // Offsets of keys and feeds, like "feeds[2].last"
+	mistyped map[string]bool  // Keys with a value of the wrong type
+	problems []*Problem
+}
+
+// Adds a problem at the key `key` in the config, or at feed `i` if
+// the key is not in the config. `key` is relative to the feed if `i`
+// is not negative.
+func (c *checker) add(key string, i int, err error) {
+	k := key
+	if i >= 0 {
+		k = fmt.Sprintf("feeds[%d]", i)
+		if len(key) > 0 {
+			k += "." + key
+		}
+	}
+	if c.mistyped[k] {
+		// Already reported.
+		return
+	}
+	off, ok := c.offsets[k]
+	if !ok && i >= 0 {
+		off, ok = c.offsets[fmt.Sprintf("feeds[%d]", i)]
+	}
+	if !ok {
+		off = -1
+	}
+	c.addAt(off, i, err)
+}
+
+// Adds a problem at byte offset `off` in the config; a negative `off`
+// is an unknown position.
+func (c *checker) addAt(off int64, i int, err error) {
+	p := &Problem{Path: c.path, Feed: i, Err: err}
+	if off >= 0 {
+		p.Line, p.Column = lineColumn(c.bs, off)
+	}
+	c.problems = append(c.problems, p)
+}
+
+// Adds a warning at the key `key` in the config; see add.
+func (c *checker) warn(key string, i int, err error) {
+	n := len(c.problems)
+	c.add(key, i, err)
+	if len(c.problems) > n {
+		c.problems[n].Warning = true
+	}
+}
+
+// Adds a warning at byte offset `off` in the config; see addAt.
+func (c *checker) warnAt(off int64, i int, err error) {
+	c.addAt(off, i, err)
+	c.problems[len(c.problems)-1].Warning = true
+}
+
+// Returns the problems ordered by their position in the config.
+func (c *checker) sorted() []*Problem {
+	sort.SliceStable(c.problems, func(i, j int) bool {
+		a, b := c.problems[i], c.problems[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.problems
+}
+
+// Reads the JSON value at `key` from `dec`, records the offsets of the
+// keys in it and adds a problem for each key that does not match a
+// field in `t` and for each value that cannot be decoded into its
+// field. If `t` is nil, the value is not checked.
+func (c *checker) scan(dec *json.Decoder, key string, t reflect.Type) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	off := c.next(dec)
+	tok, err := dec.Token()
+	if err != nil {
+		return
+	}
+	if t != nil && !decodable(tok, t) {
+		c.mistyped[key] = true
+		name := "config"
+		if len(key) > 0 {
+			name = "'" + key[strings.LastIndex(key, ".")+1:] + "'"
+		}
+		c.addAt(off, feedIndex(key), fmt.Errorf("%s must be %s", name,
+			typeName(t)))
+		t = nil
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			off := c.next(dec)
+			tok, err := dec.Token()
+			if err != nil {
+				return
+			}
+			k := tok.(string)
+			fKey := k
+			if len(key) > 0 {
+				fKey = key + "." + k
+			}
+			c.offsets[fKey] = off
+
+			var ft reflect.Type
+			if t != nil && t.Kind() == reflect.Struct {
+				f, ok := field(t, k)
+				if !ok {
+					c.warnAt(off, feedIndex(fKey),
+						fmt.Errorf("unknown key '%s'", k))
+				}
+				ft = f
+			}
+			c.scan(dec, fKey, ft)
+		}
+		dec.Token() // '}'
+	case json.Delim('['):
+		var et reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			et = t.Elem()
+		}
+		for i := 0; dec.More(); i++ {
+			eKey := fmt.Sprintf("%s[%d]", key, i)
+			c.offsets[eKey] = c.next(dec)
+			c.scan(dec, eKey, et)
+		}
+		dec.Token() // ']'
+	}
+}
+
+// Returns the offset of the next token in `dec`.
+func (c *checker) next(dec *json.Decoder) int64 {
+	off := dec.InputOffset()
+	for off < int64(len(c.bs)) && strings.IndexByte(" \t\r\n,:", c.bs[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+// Returns true if the JSON value starting with token `tok` can be
+// decoded into a value of type `t`.
+func decodable(tok json.Token, t reflect.Type) bool {
+	// Types that unmarshal themselves may take any value, except
+	// structs, which are still objects.
+	if tok == nil || (reflect.PointerTo(t).Implements(unmarshaler) &&
+		t.Kind() != reflect.Struct) {
+		return true
+	}
+	switch tok.(type) {
+	case json.Delim:
+		if tok == json.Delim('[') {
+			return t.Kind() == reflect.Slice
+		}
+		return t.Kind() == reflect.Struct || t.Kind() == reflect.Map
+	case string:
+		return t.Kind() == reflect.String
+	case float64:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int64, reflect.Float64:
+			return true
+		}
+		return false
+	case bool:
+		return t.Kind() == reflect.Bool
+	}
+	return false
+}
+
+var unmarshaler = reflect.TypeFor[json.Unmarshaler]()
+
+// Returns the index of the feed `key` is in, like 2 for
+// "feeds[2].last"; -1 if it is not in a feed.
+func feedIndex(key string) int {
+	i := -1
+	_, err := fmt.Sscanf(key, "feeds[%d]", &i)
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+// Returns the type of the field of struct `t` that the JSON key `key`
+// is decoded into. Like encoding/json, keys are matched without
+// regard to case.
+func field(t reflect.Type, key string) (reflect.Type, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		if strings.EqualFold(name, key) {
+			return f.Type, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the 1-based line and column of byte offset `off` in `bs`.
+func lineColumn(bs []byte, off int64) (int, int) {
+	if off > int64(len(bs)) {
+		off = int64(len(bs))
+	}
+	before := bs[:off]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len([]rune(string(before[bytes.LastIndexByte(before, '\n')+1:]))) + 1
+	return line, col
+}
+
+// Returns a description of the JSON type that `t` is decoded from.
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a list"
+	case reflect.Struct, reflect.Pointer, reflect.Map:
+		return "an object"
+	}
+	return t.String()
+}
+
+// Checks the values in the config and adds the problems found to `c`.
+func (config *FernConfig) check(c *checker) {
+	// Check 'output-template'.
+	err := feed.CheckOutputTemplate(config.OutputTemplate)
+	if err != nil {
+		c.add("output-template", -1, fmt.Errorf("'output-template' %v",
+			err))
+	}
+
+	// Check 'dir-template'.
+	err = feed.CheckDirTemplate(config.DirTemplate)
+	if err != nil {
+		c.add("dir-template", -1, fmt.Errorf("'dir-template' %v", err))
+	}
+
+	// Check 'dump-dir'. It is needed only if a feed does not set its
+	// own.
+	needDumpDir := len(config.Feeds) == 0
+	for _, f := range config.Feeds {
+		if len(f.DumpDir) == 0 {
+			needDumpDir = true
+		}
+	}
+	if len(config.DumpDir) > 0 {
+		if err := checkDir(config.DumpDir); err != nil {
+			c.add("dump-dir", -1, fmt.Errorf("'dump-dir' %v", err))
+		}
+	} else if needDumpDir {
+		c.add("", -1, fmt.Errorf("'dump-dir' not set"))
+	}
+
+	// Check limits.
+	for _, l := range []struct {
+		key string
+		n   int
+	}{
+		{"max-downloads", config.MaxDownloads},
+		{"max-downloads-per-host", config.MaxDownloadsPerHost},
+		{"max-feeds", config.MaxFeeds},
+	} {
+		if l.n < -1 {
+			c.add(l.key, -1, fmt.Errorf("'%s' must be -1 or more",
+				l.key))
+		}
+	}
+	for host, n := range config.HostLimits {
+		switch {
+		case len(host) == 0:
+			c.add("host-limits", -1, fmt.Errorf("empty host in"+
+				" 'host-limits'"))
+		case n == 0 || n < -1:
+			c.add("host-limits."+host, -1, fmt.Errorf("'host-limits'"+
+				" '%s' must be 1 or more, or -1 for no limit", host))
+		}
+	}
+
+	// Check 'retry' and 'quarantine'.
+	retry := feed.DefaultRetryPolicy.Override(config.Retry)
+	if err := retry.Validate(); err != nil {
+		c.add("retry", -1, fmt.Errorf("'retry' not valid: %v", err))
+	}
+	quarantine := feed.DefaultQuarantinePolicy.Override(config.Quarantine)
+	if err := quarantine.Validate(); err != nil {
+		c.add("quarantine", -1, fmt.Errorf("'quarantine' not valid: %v",
+			err))
+	}
+
+	// Check 'feeds'.
+	if len(config.Feeds) == 0 {
+		c.add("", -1, fmt.Errorf("'feeds' not set"))
+	}
+	seen := make(map[string]int)
+	globalYDL := false
+	for i := range config.Feeds {
+		// Check a copy of the feed with the policies it would
+		// have after validate.
+		f := config.Feeds[i]
+		fRetry := retry.Override(f.Retry)
+		f.Retry = &fRetry
+		fQuarantine := quarantine.Override(f.Quarantine)
+		f.Quarantine = &fQuarantine
+
+		for _, err := range f.Check() {
+			c.add(problemKey(err), i, err)
+		}
+
+		// Check 'dump-dir'.
+		if len(f.DumpDir) > 0 {
+			if err := checkDir(f.DumpDir); err != nil {
+				c.add("dump-dir", i, fmt.Errorf("'dump-dir' %v",
+					err))
+			}
+		}
+
+		// Check 'ydl-path'. It is needed only if the feed is
+		// downloaded via yt-dlp or youtube-dl, or may be, if its
+		// schema is detected.
+		switch {
+		case !f.MayUseYDL():
+		case len(f.YDLPath) > 0:
+			if err := checkProgram(f.YDLPath); err != nil {
+				c.add("ydl-path", i, fmt.Errorf("'ydl-path' %v",
+					err))
+			}
+		case len(config.YDLPath) == 0 && !f.UsesYDL():
+			c.warn("", i, fmt.Errorf("'ydl-path' not set; it is"+
+				" needed to download via yt-dlp if the feed is"+
+				" detected to be a YouTube feed"))
+		case len(config.YDLPath) == 0:
+			c.add("", i, fmt.Errorf("'ydl-path' not set; it is"+
+				" needed to download via %s", f.DownloaderName()))
+		default:
+			globalYDL = true
+		}
+
+		if j, ok := seen[f.Id]; ok && len(f.Id) > 0 {
+			c.add("id", i, fmt.Errorf("duplicate feed id; also"+
+				" feeds[%d]", j))
+		} else {
+			seen[f.Id] = i
+		}
+	}
+	if globalYDL {
+		if err := checkProgram(config.YDLPath); err != nil {
+			c.add("ydl-path", -1, fmt.Errorf("'ydl-path' %v", err))
+		}
+	}
+}
+
+// Returns the key a feed's problem `err` is about, like "last"; "" if
+// it is not known.
+func problemKey(err error) string {
+	var fe *feed.FieldError
+	if errors.As(err, &fe) {
+		return fe.Field
+	}
+	return ""
+}
+
+// Checks that the directory at `p` is a directory, if it exists. A
+// leading "~" in `p` is the user's home directory.
+func checkDir(p string) error {
+	d, err := expandHome(p)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(d)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("'%s': %v", d, pathErr(err))
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", d)
+	}
+	return nil
+}
+
+// Checks that the program at `p` exists and can be run.
+func checkProgram(p string) error {
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("'%s' does not exist", p)
+	}
+	if err != nil {
+		return fmt.Errorf("'%s': %v", p, pathErr(err))
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("'%s' is a directory", p)
+	}
+	if runtime.GOOS != "windows" && fi.Mode()&0111 == 0 {
+		return fmt.Errorf("'%s' is not executable", p)
+	}
+	return nil
+}
+
+// Returns the error underlying `err` if it is a *fs.PathError, so
+// that the path is not repeated in messages that already have it.
+func pathErr(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err
+	}
+	return err
+}
@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	dumpDir := path.Join(dir, "media")
+	write := func(name, content string) string {
+		p := path.Join(dir, name)
+		err := os.WriteFile(p, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		problems []string
+	}{
+		{
+			"valid.json",
+			`{
+  "dump-dir": "` + dumpDir + `",
+  "output-template": "%(id)s.%(ext)s",
+  "feeds": [
+    {"id": "a", "source": "https://x/a", "schema": "podcast", "last": 2},
+    {"id": "b", "source": "https://x/b", "schema": "npr", "last": 1,
+     "downloader": "native", "retry": {"max-attempts": 2},
+     "output-template": "%(title)s.%(ext)s"}
+  ]
+}`,
+			nil,
+		},
+		{
+			"syntax.json",
+			`{
+  "dump-dir": "` + dumpDir + `",
+  "feeds": [,]
+}`,
+			[]string{"syntax.json:3:13: invalid character ','"},
+		},
+		{
+			"keys.json",
+			`{
+  "dump-dir": "` + dumpDir + `",
+  "colour": "red",
+  "feeds": [
+    {"id": "a", "source": "https://x/a", "schema": "podcast",
+     "last": 2, "extra": 1}
+  ]
+}`,
+			[]string{
+				"keys.json:3:3: unknown key 'colour'",
+				"keys.json:6:17: feeds[0] 'a': unknown key 'extra'",
+			},
+		},
+		{
+			"feeds.json",
+			`{
+  "dump-dir": "` + dumpDir + `",
+  "feeds": [
+    {"id": "a", "source": "https://x/a", "schema": "podcast", "last": 2},
+    {"id": "a", "source": "ftp://x/b", "schema": "podcast", "last": 0},
+    {"source": "https://x/c", "schema": "radio", "last": "3"}
+  ]
+}`,
+			[]string{
+				"feeds.json:5:6: feeds[1] 'a': duplicate feed id; also feeds[0]",
+				"feeds.json:5:17: feeds[1] 'a': 'source' 'ftp://x/b' is not a HTTP URL",
+				"feeds.json:5:61: feeds[1] 'a': 'last' not set or 0",
+				"feeds.json:6:5: feeds[2]: 'id' not set",
+				"feeds.json:6:31: feeds[2]: 'schema' 'radio' is not valid",
+				"feeds.json:6:58: feeds[2]: 'last' must be a number",
+			},
+		},
+		{
+			"dirs.json",
+			`{
+  "dir-template": "{feed}/{week}",
+  "feeds": [
+    {"id": "a", "source": "https://x/a", "schema": "podcast", "last": 2,
+     "dir-template": "/{feed}",
+     "dump-dir": "` + dumpDir + `"}
+  ]
+}`,
+			[]string{
+				"dirs.json:2:3: 'dir-template' '{feed}/{week}' has an unknown placeholder",
+				"dirs.json:5:6: feeds[0] 'a': 'dir-template' '/{feed}' must be relative",
+			},
+		},
+		{
+			"limits.json",
+			`{
+  "dump-dir": "` + dumpDir + `",
+  "max-downloads": -2,
+  "host-limits": {"youtube.com": 0},
+  "feeds": [
+    {"id": "a", "source": "https://x/a", "schema": "podcast", "last": 2}
+  ]
+}`,
+			[]string{
+				"limits.json:3:3: 'max-downloads' must be -1 or more",
+				"limits.json:4:19: 'host-limits' 'youtube.com' must be 1 or more",
+			},
+		},
+		{
+			"auto.json",
+			`{
+  "dump-dir": "` + dumpDir + `",
+  "feeds": [
+    {"id": "a", "source": "https://x/a", "schema": "auto", "last": 2},
+    {"id": "b", "source": "https://x/b", "schema": "auto", "last": 2,
+     "downloader": "native"}
+  ]
+}`,
+			[]string{
+				"auto.json:4:5: feeds[0] 'a': 'ydl-path' not set; it is needed to download via yt-dlp if",
+			},
+		},
+		{
+			"missing.json",
+			`{"retry": {"max-attempts": 0}, "output-template": "../x"}`,
+			[]string{
+				"missing.json: 'dump-dir' not set",
+				"missing.json: 'feeds' not set",
+				"missing.json:1:2: 'retry' not valid: 'max-attempts' must be at least 1",
+				"missing.json:1:32: 'output-template' '../x' must be relative",
+			},
+		},
+	}
+	for _, test := range tests {
+		p := write(test.name, test.content)
+		problems := Check(p)
+		if len(problems) != len(test.problems) {
+			t.Errorf("%s: %d problems != %d: %v", test.name,
+				len(problems), len(test.problems), problems)
+			return
+		}
+		for i, problem := range problems {
+			msg := strings.TrimPrefix(problem.Error(), dir+"/")
+			if !strings.HasPrefix(msg, test.problems[i]) {
+				t.Errorf("%s: '%s' does not start with '%s'",
+					test.name, msg, test.problems[i])
+				return
+			}
+		}
+	}
+
+	// Check must not create the dump directory.
+	if _, err := os.Stat(dumpDir); !os.IsNotExist(err) {
+		t.Errorf("dump dir created by check: %v", err)
+		return
+	}
+
+	// Neither must Inspect.
+	if _, err := Inspect(path.Join(dir, "valid.json")); err != nil {
+		t.Errorf("inspect valid.json: %v", err)
+		return
+	}
+	if _, err := os.Stat(dumpDir); !os.IsNotExist(err) {
+		t.Errorf("dump dir created by inspect: %v", err)
+		return
+	}
+
+	// Read fails with the problems and prepares a valid config.
+	_, err := Read(path.Join(dir, "feeds.json"))
+	if err == nil || !strings.Contains(err.Error(), "duplicate feed id") {
+		t.Errorf("read feeds.json: %v", err)
+		return
+	}
+	// Unknown keys are only warnings: Read does not fail on them.
+	for _, problem := range Check(path.Join(dir, "keys.json")) {
+		if !problem.Warning {
+			t.Errorf("keys.json: not a warning: %v", problem)
+			return
+		}
+	}
+	if _, err := Read(path.Join(dir, "keys.json")); err != nil {
+		t.Errorf("read keys.json: %v", err)
+		return
+	}
+
+	// So is 'ydl-path' not being set for a feed whose schema is
+	// detected; it may not turn out to be a YouTube feed.
+	problems := Check(path.Join(dir, "auto.json"))
+	if len(problems) != 1 || !problems[0].Warning {
+		t.Errorf("auto.json: expected a warning: %v", problems)
+		return
+	}
+	config, err := Read(path.Join(dir, "valid.json"))
+	if err != nil {
+		t.Errorf("read valid.json: %v", err)
+		return
+	}
+	if _, err := os.Stat(dumpDir); err != nil {
+		t.Errorf("dump dir not created: %v", err)
+		return
+	}
+	if config.Feeds[1].Retry.MaxAttempts != 2 {
+		t.Errorf("feed retry: %v", config.Feeds[1].Retry)
+		return
+	}
+	if config.Feeds[0].OutputTemplate != "%(id)s.%(ext)s" ||
+		config.Feeds[1].OutputTemplate != "%(title)s.%(ext)s" {
+		t.Errorf("feed output templates: '%s', '%s'",
+			config.Feeds[0].OutputTemplate,
+			config.Feeds[1].OutputTemplate)
+		return
+	}
+}
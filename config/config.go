@@ -5,37 +5,118 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path"
 	"strings"
 
 	"ricketyspace.net/fern/feed"
 	"ricketyspace.net/fern/file"
+	"ricketyspace.net/fern/state"
 )
 
 // Represents the fern config
 type FernConfig struct {
-	YDLPath string      `json:"ydl-path"` // Path to the youtube-dl program.
-	DumpDir string      `json:"dump-dir"` // Path where media needs to be downloaded to.
-	Feeds   []feed.Feed `json:"feeds"`    // Feeds to download.
+	YDLPath             string                 `json:"ydl-path"`               // Path to the yt-dlp or youtube-dl program.
+	YDLArgs             []string               `json:"ydl-args"`               // Extra arguments to yt-dlp or youtube-dl.
+	OutputTemplate      string                 `json:"output-template"`        // Output template for yt-dlp or youtube-dl.
+	DumpDir             string                 `json:"dump-dir"`               // Path where media needs to be downloaded to.
+	DirTemplate         string                 `json:"dir-template"`           // Layout of a feed's media in the dump directory.
+	DB                  string                 `json:"db"`                     // Path to the fern db; optional.
+	Retry               *feed.RetryPolicy      `json:"retry"`                  // Policy for retrying failed requests and downloads.
+	Quarantine          *feed.QuarantinePolicy `json:"quarantine"`             // Policy for skipping entries that fail to download repeatedly.
+	MaxDownloads        int                    `json:"max-downloads"`          // Downloads at once; DefaultMaxDownloads if 0, no limit if -1.
+	MaxDownloadsPerHost int                    `json:"max-downloads-per-host"` // Downloads at once from a host; no limit if 0 or -1.
+	HostLimits          map[string]int         `json:"host-limits"`            // Downloads at once from a host and its subdomains; no limit if -1.
+	MaxFeeds            int                    `json:"max-feeds"`              // Feeds fetched at once; DefaultMaxFeeds if 0, no limit if -1.
+	Feeds               []feed.Feed            `json:"feeds"`                  // Feeds to download.
 }
 
-// Tries to reads the fern config at `$HOME/.config/fern/fern.json`
-// and unmarshals it into `FernConfig`
-//
-// Returns point to `FernConfig` on success. On error, the returned
-// config is `nil` and the returned error is non-nil.
-func Read() (*FernConfig, error) {
-	// Construct config file path.
+// Default limits on the downloads and feed fetches that run at once.
+const (
+	DefaultMaxDownloads = 10
+	DefaultMaxFeeds     = 10
+)
+
+// Returns the path to the fern config. It is, in order of preference:
+// `p`, if it is set; the value of the FERN_CONFIG environment
+// variable; or `$XDG_CONFIG_HOME/fern/fern.json`, where
+// XDG_CONFIG_HOME defaults to `$HOME/.config`.
+func Path(p string) (string, error) {
+	if len(p) > 0 {
+		return p, nil
+	}
+	if p = os.Getenv("FERN_CONFIG"); len(p) > 0 {
+		return p, nil
+	}
+	d, err := xdgDir("XDG_CONFIG_HOME", ".config")
+	if err != nil {
+		return "", err
+	}
+	return path.Join(d, "fern", "fern.json"), nil
+}
+
+// Returns the path to the fern db. It is, in order of preference:
+// `p`, if it is set; the value of the FERN_DB environment variable;
+// 'db' in `config`, if `config` is not nil; or
+// `$XDG_STATE_HOME/fern/db.json`, where XDG_STATE_HOME defaults to
+// `$HOME/.local/state`. If there is no db at the latter but there is
+// one at `$HOME/.config/fern/db.json`, where fern kept it before, the
+// path to that one is returned.
+func DBPath(p string, config *FernConfig) (string, error) {
+	if len(p) > 0 {
+		return p, nil
+	}
+	if p = os.Getenv("FERN_DB"); len(p) > 0 {
+		return p, nil
+	}
+	if config != nil && len(config.DB) > 0 {
+		return config.DB, nil
+	}
+
+	d, err := xdgDir("XDG_STATE_HOME", path.Join(".local", "state"))
+	if err != nil {
+		return "", err
+	}
+	p = path.Join(d, "fern", "db.json")
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
 	h, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	legacy := path.Join(h, ".config", "fern", "db.json")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	return p, nil
+}
+
+// Returns the directory in the environment variable `env` if it is set
+// to an absolute path; `$HOME/<def>` otherwise.
+func xdgDir(env, def string) (string, error) {
+	d := os.Getenv(env)
+	if path.IsAbs(d) {
+		return d, nil
+	}
+	h, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
 	}
-	c := path.Join(h, ".config", "fern", "fern.json")
+	return path.Join(h, def), nil
+}
 
+// Reads the fern config at `p`, see Path, and unmarshals it into
+// `FernConfig` without checking it.
+//
+// Returns point to `FernConfig` on success. On error, the returned
+// config is `nil` and the returned error is non-nil.
+func Load(p string) (*FernConfig, error) {
 	// Open config file.
-	f, err := os.Open(c)
+	f, err := os.Open(p)
 	if err != nil {
 		return nil, err
 	}
@@ -45,64 +126,246 @@ func Read() (*FernConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parse(p, bs)
+}
 
+// Unmarshals the fern config at `p`, whose contents are `bs`, into
+// `FernConfig`.
+//
+// If `bs` has a value of the wrong type, the returned config has
+// the other values and the returned error is a
+// *json.UnmarshalTypeError.
+func parse(p string, bs []byte) (*FernConfig, error) {
 	// Unmarshal config into an object.
 	config := new(FernConfig)
-	err = json.Unmarshal(bs, config)
+	err := json.Unmarshal(bs, config)
+	var te *json.UnmarshalTypeError
+	if err != nil && !errors.As(err, &te) {
+		return nil, err
+	}
+
+	// Resolve 'db' in config; a relative path is relative to the
+	// config's directory.
+	if len(config.DB) > 0 {
+		db, dbErr := expandHome(config.DB)
+		if dbErr != nil {
+			return nil, dbErr
+		}
+		if !path.IsAbs(db) {
+			db = path.Join(path.Dir(p), db)
+		}
+		config.DB = db
+	}
+	return config, err
+}
+
+// Tries to reads the fern config at `p`, see Path, and unmarshals it
+// into `FernConfig`. The config is checked, see Check, and prepared
+// for use: directories it names are created. Warnings found in the
+// config are logged.
+//
+// Returns point to `FernConfig` on success. On error, the returned
+// config is `nil` and the returned error is non-nil; if the config has
+// problems that are not warnings, the error joins all of them.
+func Read(p string) (*FernConfig, error) {
+	config, err := Inspect(p)
 	if err != nil {
 		return nil, err
 	}
 
-	// Validate config.
-	err = config.validate()
+	// Prepare config.
+	err = config.prepare()
 	if err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
-// Validates the FernConfig.
-//
-// Returns nil if validation succeeds; error otherwise.
-func (config *FernConfig) validate() error {
-	// Validate 'ydl-path' in config.
-	if len(config.YDLPath) == 0 {
-		return fmt.Errorf("'ydl-path' not set in config")
+// Like Read but without side effects: the config is checked and the
+// feeds' settings are resolved, but directories it names are not
+// created. For commands that only look at the config, like listing
+// its feeds.
+func Inspect(p string) (*FernConfig, error) {
+	config, problems := check(p)
+	errs := []error{}
+	for _, p := range problems {
+		if p.Warning {
+			log.Printf("Warning: %v", p)
+			continue
+		}
+		errs = append(errs, p)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
-	_, err := os.Stat(config.YDLPath)
+
+	err := config.resolve()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return config, nil
+}
 
-	// Validate 'dump-dir' in config.
-	if len(config.DumpDir) == 0 {
-		return fmt.Errorf("'dump-dir' not set in config")
+// Replaces a leading "~" in `p` with the user's home directory.
+func expandHome(p string) (string, error) {
+	if !strings.HasPrefix(p, "~") {
+		return p, nil
 	}
-	// Replace "~" with user's home directory in the dump
-	// directory path.
 	h, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return "", err
 	}
-	config.DumpDir = strings.Replace(config.DumpDir, "~", h, 1)
-	// Ensure dump directory exists.
-	err = os.MkdirAll(config.DumpDir, 0755)
+	return strings.Replace(p, "~", h, 1), nil
+}
+
+// Resolves a checked FernConfig's settings without side effects:
+// expands 'dump-dir' and sets the feeds' retry and quarantine policies
+// and, unless the feeds set them, 'dump-dir', 'dir-template',
+// 'ydl-path', 'ydl-args' and 'output-template'.
+//
+// Returns nil on success; error otherwise.
+func (config *FernConfig) resolve() error {
+	// Replace "~" with user's home directory in the dump
+	// directory path.
+	dumpDir, err := expandHome(config.DumpDir)
 	if err != nil {
 		return err
 	}
+	config.DumpDir = dumpDir
+
+	retry := feed.DefaultRetryPolicy.Override(config.Retry)
+	quarantine := feed.DefaultQuarantinePolicy.Override(config.Quarantine)
+	for i := range config.Feeds {
+		// Feed's retry policy overrides the one in config.
+		fRetry := retry.Override(config.Feeds[i].Retry)
+		config.Feeds[i].Retry = &fRetry
+		// Feed's quarantine policy overrides the one in config.
+		fQuarantine := quarantine.Override(config.Feeds[i].Quarantine)
+		config.Feeds[i].Quarantine = &fQuarantine
+
+		// Feed's dump directory and its layout override the ones
+		// in config.
+		if len(config.Feeds[i].DumpDir) == 0 {
+			config.Feeds[i].DumpDir = config.DumpDir
+		}
+		config.Feeds[i].DumpDir, err = expandHome(config.Feeds[i].DumpDir)
+		if err != nil {
+			return err
+		}
+		if len(config.Feeds[i].DirTemplate) == 0 {
+			config.Feeds[i].DirTemplate = config.DirTemplate
+		}
 
-	// Validate 'feeds' in config.
-	if len(config.Feeds) == 0 {
-		return fmt.Errorf("'feeds' not set in config")
+		// Feed's yt-dlp/youtube-dl settings override the ones in
+		// config.
+		if len(config.Feeds[i].YDLPath) == 0 {
+			config.Feeds[i].YDLPath = config.YDLPath
+		}
+		if config.Feeds[i].YDLArgs == nil {
+			config.Feeds[i].YDLArgs = config.YDLArgs
+		}
+		if len(config.Feeds[i].OutputTemplate) == 0 {
+			config.Feeds[i].OutputTemplate = config.OutputTemplate
+		}
 	}
-	for i, feed := range config.Feeds {
-		err = feed.Validate(config.DumpDir)
+	return nil
+}
+
+// Prepares a resolved FernConfig for use: ensures its dump directory
+// and the feeds' dump directories exist.
+//
+// Returns nil on success; error otherwise.
+func (config *FernConfig) prepare() error {
+	// Ensure dump directory exists, if it is needed.
+	if len(config.DumpDir) > 0 {
+		err := os.MkdirAll(config.DumpDir, 0755)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range config.Feeds {
+		err := config.Feeds[i].Validate()
 		if err != nil {
 			return err
 		}
-		config.Feeds[i].YDLPath = config.YDLPath
-		config.Feeds[i].DumpDir = feed.DumpDir
 	}
 	return nil
+}
+
+// Returns the limits on the downloads and feed fetches that run at
+// once set in the config.
+func (config *FernConfig) Limits() state.Limits {
+	// Returns the limit for the value `n` in config whose default
+	// is `def`.
+	limit := func(n, def int) int {
+		switch {
+		case n == 0:
+			return def
+		case n < 0:
+			return 0 // No limit
+		}
+		return n
+	}
+	limits := state.Limits{
+		MaxDownloads:        limit(config.MaxDownloads, DefaultMaxDownloads),
+		MaxDownloadsPerHost: limit(config.MaxDownloadsPerHost, 0),
+		HostLimits:          make(map[string]int),
+		MaxFeeds:            limit(config.MaxFeeds, DefaultMaxFeeds),
+	}
+	for host, n := range config.HostLimits {
+		limits.HostLimits[host] = limit(n, 0)
+	}
+	return limits
+}
 
+// Returns the feeds in the config whose id matches one of the
+// `patterns` or that are tagged with one of the `tags`, in the order
+// they are in the config. A pattern is a feed id or a glob of the
+// form accepted by path.Match, like "npr-*". If there are no patterns
+// and no tags, all feeds are returned.
+//
+// Returns an error if a pattern is malformed, or if a pattern or a
+// tag does not select any feed.
+func (config *FernConfig) Select(patterns, tags []string) ([]feed.Feed, error) {
+	if len(patterns) == 0 && len(tags) == 0 {
+		return config.Feeds, nil
+	}
+
+	selected := make([]bool, len(config.Feeds))
+	for _, p := range patterns {
+		found := false
+		for i, f := range config.Feeds {
+			ok, err := path.Match(p, f.Id)
+			if err != nil {
+				return nil, fmt.Errorf("feed pattern '%s': %v", p, err)
+			}
+			if ok {
+				selected[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no feed matches '%s'", p)
+		}
+	}
+	for _, tag := range tags {
+		found := false
+		for i, f := range config.Feeds {
+			if f.HasTag(tag) {
+				selected[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no feed is tagged '%s'", tag)
+		}
+	}
+
+	feeds := []feed.Feed{}
+	for i, f := range config.Feeds {
+		if selected[i] {
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds, nil
 }
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package config
+
+import (
+	"testing"
+
+	"ricketyspace.net/fern/feed"
+)
+
+func TestSelect(t *testing.T) {
+	config := FernConfig{
+		Feeds: []feed.Feed{
+			{Id: "npr-tiny-desk", Tags: []string{"music"}},
+			{Id: "npr-news", Tags: []string{"news"}},
+			{Id: "mkbhd"},
+			{Id: "kexp", Tags: []string{"music", "live"}},
+		},
+	}
+	ids := func(feeds []feed.Feed) string {
+		s := ""
+		for _, f := range feeds {
+			s += f.Id + " "
+		}
+		return s
+	}
+	tests := []struct {
+		patterns []string
+		tags     []string
+		selected string
+	}{
+		{nil, nil, "npr-tiny-desk npr-news mkbhd kexp "},
+		{[]string{"mkbhd"}, nil, "mkbhd "},
+		{[]string{"npr-*"}, nil, "npr-tiny-desk npr-news "},
+		{nil, []string{"music"}, "npr-tiny-desk kexp "},
+		{[]string{"kexp", "mkbhd"}, []string{"news"}, "npr-news mkbhd kexp "},
+		{[]string{"npr-*", "*-desk"}, nil, "npr-tiny-desk npr-news "},
+	}
+	for _, test := range tests {
+		feeds, err := config.Select(test.patterns, test.tags)
+		if err != nil {
+			t.Errorf("select %v %v: %v", test.patterns, test.tags, err)
+			return
+		}
+		if ids(feeds) != test.selected {
+			t.Errorf("select %v %v: '%s' != '%s'", test.patterns,
+				test.tags, ids(feeds), test.selected)
+			return
+		}
+	}
+
+	// Patterns and tags that select nothing.
+	for _, test := range []struct {
+		patterns []string
+		tags     []string
+	}{
+		{[]string{"bbc-*"}, nil},
+		{[]string{"mkbhd"}, []string{"sports"}},
+		{[]string{"npr-["}, nil},
+	} {
+		_, err := config.Select(test.patterns, test.tags)
+		if err == nil {
+			t.Errorf("select %v %v: expected error", test.patterns,
+				test.tags)
+			return
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	config := FernConfig{
+		MaxDownloadsPerHost: 2,
+		HostLimits:          map[string]int{"youtube.com": 1, "npr.org": -1},
+		MaxFeeds:            -1,
+	}
+	limits := config.Limits()
+	if limits.MaxDownloads != DefaultMaxDownloads {
+		t.Errorf("max downloads: %d", limits.MaxDownloads)
+		return
+	}
+	if limits.MaxDownloadsPerHost != 2 {
+		t.Errorf("max downloads per host: %d", limits.MaxDownloadsPerHost)
+		return
+	}
+	if limits.HostLimits["youtube.com"] != 1 ||
+		limits.HostLimits["npr.org"] != 0 {
+		t.Errorf("host limits: %v", limits.HostLimits)
+		return
+	}
+	if limits.MaxFeeds != 0 {
+		t.Errorf("max feeds: %d", limits.MaxFeeds)
+		return
+	}
+}
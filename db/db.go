@@ -5,81 +5,268 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"ricketyspace.net/fern/file"
+	"ricketyspace.net/fern/version"
 )
 
-var dbPath string
-var defaultDBPath string
+// Version of the on-disk format of the db.
+//
+// Version 1 db, written by fern 0.8.3 and before, maps each feed id to
+// a list of entry ids; version 2 db does not record the feeds that
+// were baselined. Both are migrated to the current version on Open.
+const dbVersion = 3
+
+// Returned when the db on disk is of a newer version than dbVersion.
+var errVersion = errors.New("FernDB version not supported")
 
 // Contains information about list of media that where already
 // download for different feeds.
 //
-// It's stored on disk as a JSON at `$HOME/.config/fern/db.json
+// It's stored on disk as a JSON file at the path given to Open.
 type FernDB struct {
+	// Path to the db on disk.
+	path string
 	// For locking concurrent read/write access downloaded.
 	mutex *sync.RWMutex
 	// Key: feed-id
-	// Value: feed-id's entries that were downloaded
-	downloaded map[string][]string
+	// Value: records of feed-id's entries that were downloaded
+	downloaded map[string][]Record
+	// Key: feed-id
+	// Value: feed-id's HTTP cache validators
+	feeds map[string]FeedCache
+	// Key: feed-id
+	// Value: failures of feed-id's entries, keyed by entry id
+	failures map[string]map[string]Failure
+	// Key: feed-id
+	// Value: time feed-id was baselined; zero if not known
+	baselined map[string]time.Time
+	// Journal of changes since the db was last written to disk;
+	// opened on first change.
+	journal *os.File
 }
 
-func init() {
-	dbPath = "" // Reset.
+// Record of a downloaded entry.
+type Record struct {
+	EntryId      string    `json:"entry-id"`
+	Title        string    `json:"title,omitempty"`
+	PubTime      time.Time `json:"pub-time"`
+	Link         string    `json:"link,omitempty"`
+	File         string    `json:"file,omitempty"`   // Path to the media on disk
+	Bytes        int64     `json:"bytes,omitempty"`  // Size of the media
+	SHA256       string    `json:"sha256,omitempty"` // Hex encoded SHA-256 of the media
+	DownloadedAt time.Time `json:"downloaded-at"`
+	Version      string    `json:"version,omitempty"` // fern version that downloaded the entry
+	// True if the entry was marked as seen without being
+	// downloaded.
+	Baseline bool `json:"baseline,omitempty"`
+}
 
-	// Construct default dbPath
-	h, err := os.UserHomeDir()
-	if err != nil {
-		return
+// HTTP cache validators of a feed, from the response to the last
+// request for the feed.
+type FeedCache struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last-modified,omitempty"`
+	// Feed's settings when the validators were stored; they are
+	// valid only as long as the settings do not change.
+	Settings string `json:"settings,omitempty"`
+	// Time the feed was last processed with the validators.
+	Processed time.Time `json:"processed"`
+}
+
+// Failed attempts to download an entry.
+type Failure struct {
+	Count       int       `json:"count"`        // Number of failed attempts
+	LastError   string    `json:"last-error"`   // Error of the last failed attempt
+	LastAttempt time.Time `json:"last-attempt"` // Time of the last failed attempt
+}
+
+// On-disk representation of FernDB.
+type dbJSON struct {
+	Version    int                           `json:"version"`
+	Downloaded map[string][]Record           `json:"downloaded"`
+	Feeds      map[string]FeedCache          `json:"feeds,omitempty"`
+	Failures   map[string]map[string]Failure `json:"failures,omitempty"`
+	Baselined  map[string]time.Time          `json:"baselined,omitempty"`
+}
+
+// Returns an empty dbJSON of the current version.
+func newDBJSON() *dbJSON {
+	return &dbJSON{
+		Version:    dbVersion,
+		Downloaded: make(map[string][]Record),
+		Feeds:      make(map[string]FeedCache),
+		Failures:   make(map[string]map[string]Failure),
+		Baselined:  make(map[string]time.Time),
 	}
-	defaultDBPath = path.Join(h, ".config", "fern", "db.json")
-	dbPath = defaultDBPath
 }
 
-// Reads the fern db from disk and unmarshals it into a FernDB
-// instance.
+// Locks the fern db at `dbPath`, reads it from disk and unmarshals
+// it into a FernDB instance. If the db does not exist, an empty db is
+// returned; the db's directory is created if it is missing.
+//
+// If the db is locked by another fern process and `wait` is true,
+// Open blocks until the lock is released; if `wait` is false, Open
+// fails with a LockedError. The lock is released by Close.
 //
 // Returns a pointer to FernDB on success; nil otherwise. The second
 // return value is non-nil on error.
-func Open() (*FernDB, error) {
+func Open(dbPath string, wait bool) (*FernDB, error) {
 	if len(dbPath) == 0 {
 		return nil, fmt.Errorf("FernDB path not set")
 	}
-
-	// Check if db exists.
-	_, err := os.Stat(dbPath)
+	err := os.MkdirAll(path.Dir(dbPath), 0755)
 	if err != nil {
-		// db does not exist yet; create an empty one.
-		db := new(FernDB)
-		db.mutex = new(sync.RWMutex)
-		db.downloaded = make(map[string][]string)
-		return db, nil
+		return nil, err
 	}
 
-	// Read db from disk.
-	f, err := os.Open(dbPath)
+	err = lock(dbPath, wait)
 	if err != nil {
 		return nil, err
 	}
-	bs, err := file.Read(f)
+	db, err := open(dbPath)
 	if err != nil {
+		unlock(dbPath)
 		return nil, err
 	}
+	return db, nil
+}
 
-	// Unmarshal db into an object.
+// Reads the fern db from disk. Meant for use by Open.
+func open(dbPath string) (*FernDB, error) {
 	db := new(FernDB)
+	db.path = dbPath
 	db.mutex = new(sync.RWMutex)
-	err = json.Unmarshal(bs, &db.downloaded)
+
+	// Read db from disk; fallback to the backup if the db is
+	// missing or corrupt.
+	dj, err := read(dbPath)
+	if err != nil {
+		bDJ, bErr := read(backupPath(dbPath))
+		switch {
+		case errors.Is(err, errVersion):
+			// Written by a newer fern; not corrupt.
+			return nil, err
+		case bErr == nil:
+			log.Printf("Warning: unable to read FernDB: %v;"+
+				" using backup %s", err, backupPath(dbPath))
+			dj = bDJ
+		case os.IsNotExist(err) && os.IsNotExist(bErr):
+			// db does not exist yet; create an empty one.
+			dj = newDBJSON()
+		case os.IsNotExist(err):
+			return nil, bErr
+		default:
+			return nil, err
+		}
+	}
+	db.downloaded = dj.Downloaded
+	db.feeds = dj.Feeds
+	db.failures = dj.Failures
+	db.baselined = dj.Baselined
+
+	// Replay changes that were not written to disk before fern
+	// exited last time.
+	err = db.journalReplay()
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
 
+// Closes the journal and releases the lock on the db. The db must not
+// be used after it is closed; changes not written to disk by Write are
+// kept in the journal and replayed by the next Open.
+func (fdb *FernDB) Close() error {
+	// Acquire write lock.
+	fdb.mutex.Lock()
+	defer fdb.mutex.Unlock() // Give up lock before returning.
+
+	if fdb.journal != nil {
+		fdb.journal.Close()
+		fdb.journal = nil
+	}
+	return unlock(fdb.path)
+}
+
+// Returns the path to the backup of the db at `dbPath`. The backup is
+// the db as it was before the last Write.
+func backupPath(dbPath string) string {
+	return dbPath + ".bak"
+}
+
+// Reads and unmarshals the db at `name`.
+func read(name string) (*dbJSON, error) {
+	bs, err := file.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	dj, err := unmarshal(bs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return dj, nil
+}
+
+// Unmarshals the db in `bs`. If the db is in the version 1 or 2
+// format, it is migrated to the current format.
+func unmarshal(bs []byte) (*dbJSON, error) {
+	dj := new(dbJSON)
+	err := json.Unmarshal(bs, dj)
+	if err == nil && (dj.Version == 2 || dj.Version == dbVersion) {
+		if dj.Downloaded == nil {
+			dj.Downloaded = make(map[string][]Record)
+		}
+		if dj.Feeds == nil {
+			dj.Feeds = make(map[string]FeedCache)
+		}
+		if dj.Failures == nil {
+			dj.Failures = make(map[string]map[string]Failure)
+		}
+		if dj.Baselined == nil {
+			dj.Baselined = make(map[string]time.Time)
+		}
+		if dj.Version == 2 {
+			// Feeds seen before are taken to be baselined.
+			dj.Version = dbVersion
+			for feed, records := range dj.Downloaded {
+				if len(records) > 0 {
+					dj.Baselined[feed] = time.Time{}
+				}
+			}
+		}
+		return dj, nil
+	}
+	if err == nil && dj.Version > dbVersion {
+		return nil, fmt.Errorf("%w: %d", errVersion, dj.Version)
+	}
+
+	// Try version 1.
+	v1 := make(map[string][]string)
+	err = json.Unmarshal(bs, &v1)
+	if err != nil {
+		return nil, err
+	}
+	dj = newDBJSON()
+	for feed, entries := range v1 {
+		dj.Downloaded[feed] = make([]Record, 0, len(entries))
+		for _, entry := range entries {
+			dj.Downloaded[feed] = append(dj.Downloaded[feed],
+				Record{EntryId: entry})
+		}
+	}
+	return dj, nil
+}
+
 // Checks if entry exists in feed. Assumes the current go routine
 // already has the mutex lock. Meant for use by the Exists and Add
 // methods.
@@ -87,8 +274,8 @@ func (fdb *FernDB) exists(feed, entry string) bool {
 	if _, ok := fdb.downloaded[feed]; !ok {
 		return false
 	}
-	for _, e := range fdb.downloaded[feed] {
-		if e == entry {
+	for _, r := range fdb.downloaded[feed] {
+		if r.EntryId == entry {
 			return true
 		}
 	}
@@ -105,63 +292,427 @@ func (fdb *FernDB) Exists(feed, entry string) bool {
 	return fdb.exists(feed, entry)
 }
 
+// Returns true if `feed` has one or more entries in the database;
+// false otherwise.
+func (fdb *FernDB) Seen(feed string) bool {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	return len(fdb.downloaded[feed]) > 0
+}
+
+// Returns true if `feed` was baselined, that is its entries were
+// marked as seen when it was first seen; false otherwise. A feed
+// stays baselined even if all its entries are forgotten.
+func (fdb *FernDB) Baselined(feed string) bool {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	_, ok := fdb.baselined[feed]
+	return ok
+}
+
+// Records that `feed` was baselined and appends it to the journal.
+//
+// Returns an error if the change could not be journaled; it is
+// recorded nevertheless.
+func (fdb *FernDB) SetBaselined(feed string) error {
+	// Acquire write lock.
+	fdb.mutex.Lock()
+	defer fdb.mutex.Unlock() // Give up lock before returning.
+
+	if _, ok := fdb.baselined[feed]; ok {
+		return nil
+	}
+	at := time.Now().UTC()
+	fdb.baselined[feed] = at
+	return fdb.journalAppend(journalOp{
+		Op:   opBaseline,
+		Feed: feed,
+		At:   &at,
+	})
+}
+
+// Returns the ids of the feeds that have records in the database,
+// sorted.
+func (fdb *FernDB) Feeds() []string {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	feeds := make([]string, 0, len(fdb.downloaded))
+	for feed := range fdb.downloaded {
+		feeds = append(feeds, feed)
+	}
+	sort.Strings(feeds)
+	return feeds
+}
+
+// Returns a copy of the records of `feed`, in the order they were
+// added to the database.
+func (fdb *FernDB) Records(feed string) []Record {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	return append([]Record(nil), fdb.downloaded[feed]...)
+}
+
 // Adds `feed` <-> `entry` to the database.
 //
 // Once a `feed` <-> `entry` is added to the database, fern assumes
 // that entry was downloaded and will not try downloading the entry
 // again.
-func (fdb *FernDB) Add(feed, entry string) {
+//
+// Returns an error if the addition could not be journaled; the entry
+// is added to the database nevertheless.
+func (fdb *FernDB) Add(feed, entry string) error {
+	return fdb.AddRecord(feed, Record{EntryId: entry})
+}
+
+// Adds `record` of a downloaded entry for `feed` to the database and
+// appends it to the journal.
+//
+// If the record's DownloadedAt or Version is not set, it is set to
+// the current time and fern's version.
+//
+// Returns an error if the addition could not be journaled; the record
+// is added to the database nevertheless.
+func (fdb *FernDB) AddRecord(feed string, record Record) error {
 	// Acquire write lock.
 	fdb.mutex.Lock()
 	defer fdb.mutex.Unlock() // Give up lock before returning.
 
+	if record.DownloadedAt.IsZero() {
+		record.DownloadedAt = time.Now().UTC()
+	}
+	if len(record.Version) == 0 {
+		record.Version = version.Version
+	}
+	if !fdb.add(feed, record) {
+		return nil
+	}
+	return fdb.journalAppend(journalOp{
+		Op:     opAdd,
+		Feed:   feed,
+		Record: &record,
+	})
+}
+
+// Adds `record` for `feed` unless an entry with the same id already
+// exists, and forgets the entry's failures. Assumes the current go
+// routine already has the mutex lock.
+//
+// Returns true if the record was added.
+func (fdb *FernDB) add(feed string, record Record) bool {
 	// Check if entry already exist for feed.
-	if fdb.exists(feed, entry) {
-		return
+	if fdb.exists(feed, record.EntryId) {
+		return false
+	}
+
+	// Entry is no longer failing.
+	delete(fdb.failures[feed], record.EntryId)
+	if len(fdb.failures[feed]) == 0 {
+		delete(fdb.failures, feed)
 	}
 
 	// Add entry.
 	if _, ok := fdb.downloaded[feed]; !ok {
-		fdb.downloaded[feed] = make([]string, 0)
+		fdb.downloaded[feed] = make([]Record, 0)
+	}
+	fdb.downloaded[feed] = append(fdb.downloaded[feed], record)
+	return true
+}
+
+// Removes `entry` of `feed`, and its failures, from the database and
+// appends the removal to the journal. fern downloads the entry again
+// if it is still in the feed.
+//
+// Returns true if the entry, or failures of it, were in the database.
+// The error is non-nil if the removal could not be journaled; the
+// entry is removed from the database nevertheless.
+func (fdb *FernDB) Forget(feed, entry string) (bool, error) {
+	// Acquire write lock.
+	fdb.mutex.Lock()
+	defer fdb.mutex.Unlock() // Give up lock before returning.
+
+	if !fdb.forget(feed, entry) {
+		return false, nil
+	}
+	return true, fdb.journalAppend(journalOp{
+		Op:    opForget,
+		Feed:  feed,
+		Entry: entry,
+	})
+}
+
+// Removes `entry` of `feed`, and its failures, from the database.
+// The feed's HTTP cache validators are removed too, so that the feed
+// is processed again even if it did not change. Assumes the current go
+// routine already has the mutex lock.
+//
+// Returns true if the entry, or failures of it, were in the database.
+func (fdb *FernDB) forget(feed, entry string) bool {
+	_, failed := fdb.failures[feed][entry]
+	delete(fdb.failures[feed], entry)
+	if len(fdb.failures[feed]) == 0 {
+		delete(fdb.failures, feed)
 	}
-	fdb.downloaded[feed] = append(fdb.downloaded[feed], entry)
 
+	records := fdb.downloaded[feed]
+	for i, r := range records {
+		if r.EntryId != entry {
+			continue
+		}
+		records = append(records[:i:i], records[i+1:]...)
+		if len(records) == 0 {
+			delete(fdb.downloaded, feed)
+		} else {
+			fdb.downloaded[feed] = records
+		}
+		delete(fdb.feeds, feed)
+		return true
+	}
+	if failed {
+		delete(fdb.feeds, feed)
+	}
+	return failed
+}
+
+// Removes the feeds that are not in `keep` from the database, along
+// with their entries, failures and HTTP cache validators, and appends
+// the removals to the journal.
+//
+// Returns the ids of the removed feeds, sorted. The error is non-nil
+// if a removal could not be journaled; the feeds are removed from the
+// database nevertheless.
+func (fdb *FernDB) Prune(keep []string) ([]string, error) {
+	// Acquire write lock.
+	fdb.mutex.Lock()
+	defer fdb.mutex.Unlock() // Give up lock before returning.
+
+	kept := make(map[string]bool, len(keep))
+	for _, feed := range keep {
+		kept[feed] = true
+	}
+	pruned := []string{}
+	prune := func(feed string) {
+		if !kept[feed] && !slices.Contains(pruned, feed) {
+			pruned = append(pruned, feed)
+		}
+	}
+	for feed := range fdb.downloaded {
+		prune(feed)
+	}
+	for feed := range fdb.feeds {
+		prune(feed)
+	}
+	for feed := range fdb.failures {
+		prune(feed)
+	}
+	for feed := range fdb.baselined {
+		prune(feed)
+	}
+	sort.Strings(pruned)
+
+	var jErr error
+	for _, feed := range pruned {
+		fdb.drop(feed)
+		err := fdb.journalAppend(journalOp{Op: opDrop, Feed: feed})
+		if err != nil && jErr == nil {
+			jErr = err
+		}
+	}
+	return pruned, jErr
+}
+
+// Removes `feed` from the database. Assumes the current go routine
+// already has the mutex lock.
+func (fdb *FernDB) drop(feed string) {
+	delete(fdb.downloaded, feed)
+	delete(fdb.feeds, feed)
+	delete(fdb.failures, feed)
+	delete(fdb.baselined, feed)
+}
+
+// Returns the failures of `entry` in `feed`. The returned Failure is
+// empty if entry has not failed since it was last downloaded.
+func (fdb *FernDB) Failure(feed, entry string) Failure {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	return fdb.failures[feed][entry]
+}
+
+// Returns a copy of the failures of the entries in `feed`, keyed by
+// entry id.
+func (fdb *FernDB) Failures(feed string) map[string]Failure {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	failures := make(map[string]Failure, len(fdb.failures[feed]))
+	for entry, f := range fdb.failures[feed] {
+		failures[entry] = f
+	}
+	return failures
+}
+
+// Records a failed attempt to download `entry` in `feed` with `err`
+// and appends it to the journal. The entry's failures are forgotten
+// once the entry is added to the database.
+//
+// Returns an error if the failure could not be journaled; the failure
+// is recorded nevertheless.
+func (fdb *FernDB) AddFailure(feed, entry string, err error) error {
+	// Acquire write lock.
+	fdb.mutex.Lock()
+	defer fdb.mutex.Unlock() // Give up lock before returning.
+
+	f := fdb.failures[feed][entry]
+	f.Count += 1
+	f.LastError = err.Error()
+	f.LastAttempt = time.Now().UTC()
+	fdb.setFailure(feed, entry, f)
+	return fdb.journalAppend(journalOp{
+		Op:      opFail,
+		Feed:    feed,
+		Entry:   entry,
+		Failure: &f,
+	})
+}
+
+// Sets the failures of `entry` in `feed` to `f`. Assumes the current
+// go routine already has the mutex lock.
+func (fdb *FernDB) setFailure(feed, entry string, f Failure) {
+	if _, ok := fdb.failures[feed]; !ok {
+		fdb.failures[feed] = make(map[string]Failure)
+	}
+	fdb.failures[feed][entry] = f
+}
+
+// Returns the HTTP cache validators stored for `feed`. The returned
+// FeedCache is empty if there are none.
+func (fdb *FernDB) FeedCache(feed string) FeedCache {
+	// Acquire read lock.
+	fdb.mutex.RLock()
+	defer fdb.mutex.RUnlock() // Give up lock before returning.
+
+	return fdb.feeds[feed]
+}
+
+// Stores the HTTP cache validators `fc` for `feed` and appends them
+// to the journal. An empty `fc` removes the validators stored for
+// feed.
+//
+// Returns an error if the change could not be journaled; the
+// validators are stored nevertheless.
+func (fdb *FernDB) SetFeedCache(feed string, fc FeedCache) error {
+	// Acquire write lock.
+	fdb.mutex.Lock()
+	defer fdb.mutex.Unlock() // Give up lock before returning.
+
+	if fdb.feeds[feed] == fc {
+		return nil
+	}
+	fdb.setFeedCache(feed, fc)
+	return fdb.journalAppend(journalOp{
+		Op:    opCache,
+		Feed:  feed,
+		Cache: &fc,
+	})
+}
+
+// Stores `fc` for `feed`. Assumes the current go routine already has
+// the mutex lock.
+func (fdb *FernDB) setFeedCache(feed string, fc FeedCache) {
+	if fc == (FeedCache{}) {
+		delete(fdb.feeds, feed)
+		return
+	}
+	fdb.feeds[feed] = fc
 }
 
 // Writes FernDB to disk in the JSON format.
 //
+// The db is replaced atomically; the db it replaces is kept as a
+// backup next to it and is used by Open if the db turns out to be
+// missing or corrupt.
+//
 // Returns nil on success; error otherwise
 func (fdb *FernDB) Write() error {
 	// Acquire write lock.
 	fdb.mutex.Lock()
 	defer fdb.mutex.Unlock() // Give up lock before returning.
 
-	if len(dbPath) == 0 {
-		return fmt.Errorf("FernDB path not set")
+	// Marshal database into json.
+	bs, err := json.Marshal(dbJSON{
+		Version:    dbVersion,
+		Downloaded: fdb.downloaded,
+		Feeds:      fdb.feeds,
+		Failures:   fdb.failures,
+		Baselined:  fdb.baselined,
+	})
+	if err != nil {
+		return err
 	}
 
-	f, err := os.OpenFile(dbPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	// Write to a temporary file next to the db and flush it to
+	// disk, so that a crash does not leave a partially written
+	// db behind.
+	dir := path.Dir(fdb.path)
+	f, err := os.CreateTemp(dir, path.Base(fdb.path)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name()) // No-op after rename.
 	defer f.Close()
-
-	// Marshal database into json.
-	bs, err := json.Marshal(fdb.downloaded)
+	_, err = f.Write(bs)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(0644)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
 	if err != nil {
 		return err
 	}
 
-	// Write to disk.
-	_, err = f.Write(bs)
+	// Keep the current db as the backup and move the new db into
+	// place.
+	if _, err := os.Stat(fdb.path); err == nil {
+		err = os.Rename(fdb.path, backupPath(fdb.path))
+		if err != nil {
+			return err
+		}
+	}
+	err = os.Rename(f.Name(), fdb.path)
 	if err != nil {
 		return err
 	}
-	return nil
+	syncDir(dir)
+
+	// Changes in the journal are now in the db.
+	return fdb.journalRemove()
 }
 
-// Sets DB path to the default path. This function is meant to be used
-// by tests.
-func resetDBPath() {
-	dbPath = defaultDBPath
+// Flushes the directory entries in `dir` to disk, so that renames in
+// it survive a crash. Errors are ignored; not all platforms support
+// syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	d.Sync()
 }
@@ -4,10 +4,14 @@
 package db
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"testing"
+	"time"
+
+	"ricketyspace.net/fern/version"
 )
 
 func stringsContain(haystack []string, needle string) bool {
@@ -20,11 +24,7 @@ func stringsContain(haystack []string, needle string) bool {
 }
 
 func TestOpenPathNotSet(t *testing.T) {
-	// Set custom path for db.
-	dbPath = ""
-	defer os.Remove(dbPath)
-
-	_, err := Open()
+	_, err := Open("", false)
 	if err == nil {
 		t.Errorf("Error: db.Open did not fail when dbPath is empty\n")
 		return
@@ -36,12 +36,11 @@ func TestOpenPathNotSet(t *testing.T) {
 }
 
 func TestOpenNewDB(t *testing.T) {
-	// Set custom path for db.
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
+	// Set custom path for db, in a directory that does not exist.
+	dbPath := path.Join(t.TempDir(), "state", "fern", "fern-db.json")
 
 	// Open empty db.
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -60,12 +59,18 @@ func TestOpenNewDB(t *testing.T) {
 		t.Errorf("db.downloaded is nil")
 		return
 	}
+
+	// Verify that the db can be written.
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+	db.Close()
 }
 
 func TestOpenExistingDB(t *testing.T) {
 	// Set custom path for db.
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
 
 	// Write a sample test db to fern-db.json
 	testDBJSON := []byte(`{"mkbhd":["rivian","v-raptor","m1-imac"],"npr":["william-prince","joy-oladokun","lucy-ducas"],"simone":["weightless","ugly-desks","safety-hat"]}`)
@@ -82,7 +87,7 @@ func TestOpenExistingDB(t *testing.T) {
 	}
 
 	// Open the db.
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -97,7 +102,8 @@ func TestOpenExistingDB(t *testing.T) {
 	db.mutex.Unlock()
 
 	// Validate db.downloaded.
-	var entries, expectedEntries []string
+	var entries []Record
+	var expectedEntries []string
 	var ok bool
 	if len(db.downloaded) != 3 {
 		t.Errorf("db.downloaded does not contain 3 feeds")
@@ -110,7 +116,7 @@ func TestOpenExistingDB(t *testing.T) {
 	}
 	expectedEntries = []string{"rivian", "v-raptor", "m1-imac"}
 	for _, entry := range entries {
-		if !stringsContain(expectedEntries, entry) {
+		if !stringsContain(expectedEntries, entry.EntryId) {
 			t.Errorf("%v does not exist in db.downloaded[mkbhd]", entry)
 			return
 		}
@@ -122,7 +128,7 @@ func TestOpenExistingDB(t *testing.T) {
 	}
 	expectedEntries = []string{"weightless", "ugly-desks", "safety-hat"}
 	for _, entry := range entries {
-		if !stringsContain(expectedEntries, entry) {
+		if !stringsContain(expectedEntries, entry.EntryId) {
 			t.Errorf("%v does not exist in db.downloaded[simone]", entry)
 			return
 		}
@@ -134,7 +140,7 @@ func TestOpenExistingDB(t *testing.T) {
 	}
 	expectedEntries = []string{"william-prince", "lucy-ducas", "joy-oladokun"}
 	for _, entry := range entries {
-		if !stringsContain(expectedEntries, entry) {
+		if !stringsContain(expectedEntries, entry.EntryId) {
 			t.Errorf("%v does not exist in db.downloaded[npr]", entry)
 			return
 		}
@@ -143,8 +149,7 @@ func TestOpenExistingDB(t *testing.T) {
 
 func TestExists(t *testing.T) {
 	// Set custom path for db.
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
 
 	// Write a sample test db to fern-db.json
 	testDBJSON := []byte(`{"npr":["william-prince","joy-oladokun","lucy-ducas"]}`)
@@ -161,7 +166,7 @@ func TestExists(t *testing.T) {
 	}
 
 	// Open the db.
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -186,8 +191,7 @@ func TestExists(t *testing.T) {
 
 func TestAdd(t *testing.T) {
 	// Set custom path for db.
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
 
 	// Write a sample test db to fern-db.json
 	testDBJSON := []byte(`{"npr":["william-prince","joy-oladokun"]}`)
@@ -204,7 +208,7 @@ func TestAdd(t *testing.T) {
 	}
 
 	// Open the db.
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -252,11 +256,10 @@ func TestAdd(t *testing.T) {
 
 func TestWriteNewDB(t *testing.T) {
 	// Set custom path for db.
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
 
 	// Open the db.
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -269,7 +272,8 @@ func TestWriteNewDB(t *testing.T) {
 	db.Write()
 
 	// Read db refreshly from disk and verify the db contents.
-	db, err = Open()
+	db.Close()
+	db, err = Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -301,8 +305,7 @@ func TestWriteNewDB(t *testing.T) {
 
 func TestWriteExistingDB(t *testing.T) {
 	// Set custom path for db.
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
 
 	// Write a sample test db to fern-db.json
 	testDBJSON := []byte(`{"npr":["kurt-vile","joy-oladokun"]}`)
@@ -319,7 +322,7 @@ func TestWriteExistingDB(t *testing.T) {
 	}
 
 	// Open the db.
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -332,7 +335,8 @@ func TestWriteExistingDB(t *testing.T) {
 	db.Write()
 
 	// Read db refreshly from disk and verify the db contents.
-	db, err = Open()
+	db.Close()
+	db, err = Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db.Open failed: %v", err.Error())
 		return
@@ -373,11 +377,9 @@ func TestWriteExistingDB(t *testing.T) {
 }
 
 func TestConcurrentWrites(t *testing.T) {
-	dbPath = path.Join(os.TempDir(), "fern-db.json")
-	defer os.Remove(dbPath)
-	defer resetDBPath()
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
 
-	db, err := Open()
+	db, err := Open(dbPath, false)
 	if err != nil {
 		t.Errorf("db open failed: %v", err)
 		return
@@ -417,3 +419,779 @@ func TestConcurrentWrites(t *testing.T) {
 			numEntries, db.downloaded[feed])
 	}
 }
+
+func TestAddRecord(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+
+	pubTime := time.Date(2022, 11, 22, 10, 0, 0, 0, time.UTC)
+	record := Record{
+		EntryId: "joy-oladokun",
+		Title:   "Joy Oladokun: Tiny Desk Concert",
+		PubTime: pubTime,
+		Link:    "https://example.org/joy-oladokun.mp4",
+		File:    "/media/npr/joy-oladokun.mp4",
+		Bytes:   42,
+		SHA256:  "73475cb40a568e8da8a045ced110137e159f890ac4da883b6b17dc651b3a8049",
+	}
+	db.AddRecord("npr", record)
+	db.AddRecord("npr", Record{EntryId: "joy-oladokun", Title: "dup"})
+	if len(db.downloaded["npr"]) != 1 {
+		t.Errorf("db.AddRecord failed: expected 1 entry for 'npr'")
+		return
+	}
+	err = db.Write()
+	if err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+
+	// Read db refreshly from disk and verify the record.
+	db.Close()
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if len(db.downloaded["npr"]) != 1 {
+		t.Errorf("db.Open: expected 1 entry for 'npr'")
+		return
+	}
+	r := db.downloaded["npr"][0]
+	if r.DownloadedAt.IsZero() {
+		t.Errorf("record downloaded-at not set")
+		return
+	}
+	if r.Version != version.Version {
+		t.Errorf("record version: %s != %s", r.Version, version.Version)
+		return
+	}
+	r.DownloadedAt = time.Time{}
+	r.Version = ""
+	if r != record {
+		t.Errorf("record: %v != %v", r, record)
+		return
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	// Write a version 1 db to fern-db.json
+	testDBJSON := []byte(`{"npr":["kurt-vile","joy-oladokun"]}`)
+	err := os.WriteFile(dbPath, testDBJSON, 0644)
+	if err != nil {
+		t.Errorf("Unable to write fern-db.json: %v", err.Error())
+		return
+	}
+
+	// Open the db and write it back in the current format.
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	err = db.Write()
+	if err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+	bs, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Errorf("Unable to read fern-db.json: %v", err.Error())
+		return
+	}
+	dj := new(dbJSON)
+	err = json.Unmarshal(bs, dj)
+	if err != nil {
+		t.Errorf("Unable to unmarshal fern-db.json: %v", err.Error())
+		return
+	}
+	if dj.Version != dbVersion {
+		t.Errorf("db version: %d != %d", dj.Version, dbVersion)
+		return
+	}
+	if len(dj.Downloaded["npr"]) != 2 {
+		t.Errorf("db: expected 2 entries for 'npr'")
+		return
+	}
+	for _, entry := range []string{"kurt-vile", "joy-oladokun"} {
+		if !db.Exists("npr", entry) {
+			t.Errorf("db: expected %s in 'npr' feed", entry)
+			return
+		}
+	}
+
+	// A db from a newer fern must not be opened.
+	err = os.WriteFile(dbPath, []byte(`{"version":42,"downloaded":{}}`), 0644)
+	if err != nil {
+		t.Errorf("Unable to write fern-db.json: %v", err.Error())
+		return
+	}
+	db.Close()
+	if _, err = Open(dbPath, false); err == nil {
+		t.Errorf("db.Open did not fail for version 42")
+		return
+	}
+}
+
+func TestWriteBackup(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+
+	// First write; there is nothing to backup yet.
+	db.Add("npr", "kurt-vile")
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+	if _, err = os.Stat(backupPath(dbPath)); err == nil {
+		t.Errorf("backup exists after first write")
+		return
+	}
+
+	// Second write; the first write must become the backup.
+	db.Add("npr", "joy-oladokun")
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+	dj, err := read(backupPath(dbPath))
+	if err != nil {
+		t.Errorf("read backup: %v", err)
+		return
+	}
+	if len(dj.Downloaded["npr"]) != 1 ||
+		dj.Downloaded["npr"][0].EntryId != "kurt-vile" {
+		t.Errorf("backup: unexpected content: %v", dj.Downloaded)
+		return
+	}
+
+	// No temporary files must be left behind.
+	db.Close()
+	des, err := os.ReadDir(path.Dir(dbPath))
+	if err != nil {
+		t.Errorf("read db dir: %v", err)
+		return
+	}
+	if len(des) != 2 {
+		t.Errorf("db dir: expected 2 files, got %d", len(des))
+		return
+	}
+}
+
+func TestOpenBackup(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	// Write a valid backup.
+	err := os.WriteFile(backupPath(dbPath),
+		[]byte(`{"npr":["kurt-vile","joy-oladokun"]}`), 0644)
+	if err != nil {
+		t.Errorf("Unable to write backup: %v", err.Error())
+		return
+	}
+
+	// db is missing; backup must be used.
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if !db.Exists("npr", "kurt-vile") {
+		t.Errorf("db.Open: expected kurt-vile in 'npr' feed")
+		return
+	}
+
+	// db is corrupt; backup must be used.
+	err = os.WriteFile(dbPath, []byte(`{"npr":["kurt-vile",`), 0644)
+	if err != nil {
+		t.Errorf("Unable to write fern-db.json: %v", err.Error())
+		return
+	}
+	db.Close()
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if !db.Exists("npr", "joy-oladokun") {
+		t.Errorf("db.Open: expected joy-oladokun in 'npr' feed")
+		return
+	}
+
+	// Both db and backup are corrupt.
+	err = os.WriteFile(backupPath(dbPath), []byte(`{`), 0644)
+	if err != nil {
+		t.Errorf("Unable to write backup: %v", err.Error())
+		return
+	}
+	db.Close()
+	if _, err = Open(dbPath, false); err == nil {
+		t.Errorf("db.Open did not fail for corrupt db and backup")
+		return
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	db.Add("npr", "kurt-vile")
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+	if _, err = os.Stat(journalPath(dbPath)); err == nil {
+		t.Errorf("journal exists after write")
+		return
+	}
+
+	// Add entries without writing the db to disk, as if fern
+	// was killed.
+	if err = db.Add("npr", "joy-oladokun"); err != nil {
+		t.Errorf("db.Add failed: %v", err)
+		return
+	}
+	if err = db.Add("mkbhd", "v-raptor"); err != nil {
+		t.Errorf("db.Add failed: %v", err)
+		return
+	}
+	db.Close()
+
+	// Append a partial operation, as if fern was killed while
+	// appending it.
+	f, err := os.OpenFile(journalPath(dbPath), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Errorf("open journal: %v", err)
+		return
+	}
+	_, err = f.Write([]byte(`{"op":"add","feed":"npr","rec`))
+	f.Close()
+	if err != nil {
+		t.Errorf("write journal: %v", err)
+		return
+	}
+
+	// Open db; the journal must be replayed.
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	for _, fe := range [][]string{
+		{"npr", "kurt-vile"},
+		{"npr", "joy-oladokun"},
+		{"mkbhd", "v-raptor"},
+	} {
+		if !db.Exists(fe[0], fe[1]) {
+			t.Errorf("db: expected %s in '%s' feed", fe[1], fe[0])
+			return
+		}
+	}
+	if len(db.downloaded["npr"]) != 2 {
+		t.Errorf("db: expected 2 entries for 'npr'")
+		return
+	}
+	if db.downloaded["npr"][1].DownloadedAt.IsZero() {
+		t.Errorf("db: replayed record downloaded-at not set")
+		return
+	}
+}
+
+func TestLock(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+
+	// db is locked by this process.
+	_, err = Open(dbPath, false)
+	if le, ok := err.(*LockedError); !ok || le.Pid != os.Getpid() {
+		t.Errorf("db.Open: expected LockedError, got: %v", err)
+		return
+	}
+
+	// Wait for the lock to be released.
+	lockPollInterval = 10 * time.Millisecond
+	defer func() { lockPollInterval = time.Second }()
+	dbc := make(chan *FernDB)
+	go func() {
+		db, err := Open(dbPath, true)
+		if err != nil {
+			t.Errorf("db.Open failed: %v", err.Error())
+		}
+		dbc <- db
+	}()
+	time.Sleep(50 * time.Millisecond)
+	db.Close()
+	db = <-dbc
+	if db == nil {
+		return
+	}
+	db.Close()
+	if _, err = os.Stat(lockPath(dbPath)); err == nil {
+		t.Errorf("lock exists after close")
+		return
+	}
+
+	// Stale lock held by a process that is not running.
+	err = os.WriteFile(lockPath(dbPath), []byte("99999999\n"), 0644)
+	if err != nil {
+		t.Errorf("write lock: %v", err)
+		return
+	}
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed for stale lock: %v", err.Error())
+		return
+	}
+	db.Close()
+
+	// Unreadable lock that is left behind.
+	err = os.WriteFile(lockPath(dbPath), []byte{}, 0644)
+	if err != nil {
+		t.Errorf("write lock: %v", err)
+		return
+	}
+	old := time.Now().Add(-2 * lockWriteTimeout)
+	err = os.Chtimes(lockPath(dbPath), old, old)
+	if err != nil {
+		t.Errorf("chtimes lock: %v", err)
+		return
+	}
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed for unreadable lock: %v", err.Error())
+		return
+	}
+	db.Close()
+
+	// Stale lock taken over by several at once; only one must get
+	// it.
+	err = os.WriteFile(lockPath(dbPath), []byte("99999999\n"), 0644)
+	if err != nil {
+		t.Errorf("write lock: %v", err)
+		return
+	}
+	lockPollInterval = time.Millisecond
+	dbc = make(chan *FernDB)
+	for i := 0; i < 10; i++ {
+		go func() {
+			db, _ := Open(dbPath, false)
+			dbc <- db
+		}()
+	}
+	opened := 0
+	for i := 0; i < 10; i++ {
+		if db := <-dbc; db != nil {
+			opened += 1
+			defer db.Close()
+		}
+	}
+	if opened != 1 {
+		t.Errorf("db.Open: stale lock taken over %d times", opened)
+		return
+	}
+	if _, err = os.Stat(takeOverPath(dbPath)); err == nil {
+		t.Errorf("take over file exists after take over")
+		return
+	}
+}
+
+func TestFeedCache(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if db.FeedCache("npr") != (FeedCache{}) {
+		t.Errorf("db.FeedCache: expected empty cache for 'npr'")
+		return
+	}
+	npr := FeedCache{ETag: `"42"`, Settings: "5"}
+	mkbhd := FeedCache{LastModified: "Tue, 22 Nov 2022 10:00:00 GMT"}
+	db.SetFeedCache("npr", npr)
+	db.SetFeedCache("mkbhd", mkbhd)
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+
+	// Change validators without writing db to disk.
+	npr.ETag = `"43"`
+	if err = db.SetFeedCache("npr", npr); err != nil {
+		t.Errorf("db.SetFeedCache failed: %v", err)
+		return
+	}
+	if err = db.SetFeedCache("mkbhd", FeedCache{}); err != nil {
+		t.Errorf("db.SetFeedCache failed: %v", err)
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if db.FeedCache("npr") != npr {
+		t.Errorf("db.FeedCache: %v != %v", db.FeedCache("npr"), npr)
+		return
+	}
+	if _, ok := db.feeds["mkbhd"]; ok {
+		t.Errorf("db.FeedCache: expected no cache for 'mkbhd'")
+		return
+	}
+}
+
+func TestFailures(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	for _, e := range []string{"1", "1", "2"} {
+		err = db.AddFailure("npr", e, fmt.Errorf("failed %s", e))
+		if err != nil {
+			t.Errorf("db.AddFailure failed: %v", err)
+			return
+		}
+	}
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+
+	// Fail again without writing db to disk.
+	if err = db.AddFailure("npr", "2", fmt.Errorf("failed again")); err != nil {
+		t.Errorf("db.AddFailure failed: %v", err)
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	f := db.Failure("npr", "1")
+	if f.Count != 2 || f.LastError != "failed 1" || f.LastAttempt.IsZero() {
+		t.Errorf("db.Failure: unexpected failure for '1': %v", f)
+		return
+	}
+	f = db.Failure("npr", "2")
+	if f.Count != 2 || f.LastError != "failed again" {
+		t.Errorf("db.Failure: unexpected failure for '2': %v", f)
+		return
+	}
+	if db.Failure("npr", "3") != (Failure{}) {
+		t.Errorf("db.Failure: expected no failure for '3'")
+		return
+	}
+
+	// Downloading an entry forgets its failures.
+	if err = db.Add("npr", "1"); err != nil {
+		t.Errorf("db.Add failed: %v", err)
+		return
+	}
+	if db.Failure("npr", "1") != (Failure{}) {
+		t.Errorf("db.Failure: expected no failure for '1' after add")
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	defer db.Close()
+	if db.Failure("npr", "1") != (Failure{}) {
+		t.Errorf("db.Failure: expected no failure for '1' after replay")
+		return
+	}
+	if db.Failure("npr", "2").Count != 2 {
+		t.Errorf("db.Failure: expected failures for '2' after replay")
+		return
+	}
+}
+
+func TestRecords(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	defer db.Close()
+	db.Add("npr", "2")
+	db.Add("npr", "1")
+	db.Add("mkbhd", "a")
+	db.AddFailure("npr", "3", fmt.Errorf("failed"))
+	db.AddFailure("kexp", "x", fmt.Errorf("failed"))
+
+	if !db.Seen("npr") || db.Seen("kexp") || db.Seen("none") {
+		t.Errorf("db.Seen: expected only 'npr' and 'mkbhd' seen")
+		return
+	}
+	feeds := db.Feeds()
+	if len(feeds) != 2 || feeds[0] != "mkbhd" || feeds[1] != "npr" {
+		t.Errorf("db.Feeds: %v", feeds)
+		return
+	}
+	records := db.Records("npr")
+	if len(records) != 2 || records[0].EntryId != "2" ||
+		records[1].EntryId != "1" {
+		t.Errorf("db.Records: %v", records)
+		return
+	}
+	records[0].EntryId = "changed"
+	if db.Records("npr")[0].EntryId != "2" {
+		t.Errorf("db.Records: returned records are not a copy")
+		return
+	}
+	if len(db.Records("none")) != 0 {
+		t.Errorf("db.Records: expected no records for 'none'")
+		return
+	}
+	failures := db.Failures("npr")
+	if len(failures) != 1 || failures["3"].Count != 1 {
+		t.Errorf("db.Failures: %v", failures)
+		return
+	}
+}
+
+func TestForget(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	db.Add("npr", "1")
+	db.Add("npr", "2")
+	db.Add("mkbhd", "a")
+	db.AddFailure("npr", "1", fmt.Errorf("failed"))
+	db.SetFeedCache("npr", FeedCache{ETag: `"42"`})
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+
+	// Forget without writing db to disk.
+	for _, e := range []string{"1", "3"} {
+		ok, err := db.Forget("npr", e)
+		if err != nil {
+			t.Errorf("db.Forget failed: %v", err)
+			return
+		}
+		if ok != (e == "1") {
+			t.Errorf("db.Forget: unexpected result for '%s': %v", e, ok)
+			return
+		}
+	}
+	if _, err = db.Forget("mkbhd", "a"); err != nil {
+		t.Errorf("db.Forget failed: %v", err)
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	defer db.Close()
+	if db.Exists("npr", "1") || !db.Exists("npr", "2") {
+		t.Errorf("db.Forget: expected only 'npr' '2' to exist")
+		return
+	}
+	if db.Failure("npr", "1") != (Failure{}) {
+		t.Errorf("db.Forget: expected failures of '1' to be forgotten")
+		return
+	}
+	if db.FeedCache("npr") != (FeedCache{}) {
+		t.Errorf("db.Forget: expected cache of 'npr' to be removed")
+		return
+	}
+	if db.Seen("mkbhd") {
+		t.Errorf("db.Forget: expected 'mkbhd' to not be seen")
+		return
+	}
+
+	// Entry that only failed.
+	db.AddFailure("npr", "4", fmt.Errorf("failed"))
+	db.SetFeedCache("npr", FeedCache{ETag: `"43"`})
+	ok, err := db.Forget("npr", "4")
+	if err != nil || !ok {
+		t.Errorf("db.Forget: unexpected result for failed '4': %v: %v",
+			ok, err)
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	defer db.Close()
+	if db.Failure("npr", "4") != (Failure{}) {
+		t.Errorf("db.Forget: expected failures of '4' to be forgotten")
+		return
+	}
+	if db.FeedCache("npr") != (FeedCache{}) {
+		t.Errorf("db.Forget: expected cache of 'npr' to be removed")
+		return
+	}
+	if ok, _ = db.Forget("npr", "4"); ok {
+		t.Errorf("db.Forget: expected '4' to not be in the database")
+		return
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	db.Add("npr", "1")
+	db.Add("mkbhd", "a")
+	db.SetFeedCache("kexp", FeedCache{ETag: `"42"`})
+	db.AddFailure("bbc", "x", fmt.Errorf("failed"))
+
+	pruned, err := db.Prune([]string{"npr", "new"})
+	if err != nil {
+		t.Errorf("db.Prune failed: %v", err)
+		return
+	}
+	if fmt.Sprint(pruned) != "[bbc kexp mkbhd]" {
+		t.Errorf("db.Prune: unexpected pruned feeds: %v", pruned)
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	defer db.Close()
+	if !db.Exists("npr", "1") || db.Seen("mkbhd") ||
+		db.FeedCache("kexp") != (FeedCache{}) ||
+		db.Failure("bbc", "x") != (Failure{}) {
+		t.Errorf("db.Prune: unexpected db after replay")
+		return
+	}
+	pruned, err = db.Prune([]string{"npr"})
+	if err != nil || len(pruned) != 0 {
+		t.Errorf("db.Prune: unexpected result: %v %v", pruned, err)
+		return
+	}
+}
+
+func TestBaselined(t *testing.T) {
+	dbPath := path.Join(t.TempDir(), "fern-db.json")
+
+	db, err := Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if db.Baselined("npr") {
+		t.Errorf("db.Baselined: expected 'npr' to not be baselined")
+		return
+	}
+	if err = db.SetBaselined("npr"); err != nil {
+		t.Errorf("db.SetBaselined failed: %v", err)
+		return
+	}
+	if err = db.Write(); err != nil {
+		t.Errorf("db.Write failed: %v", err)
+		return
+	}
+
+	// Baseline without writing db to disk.
+	if err = db.SetBaselined("mkbhd"); err != nil {
+		t.Errorf("db.SetBaselined failed: %v", err)
+		return
+	}
+	db.Close()
+
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	if !db.Baselined("npr") || !db.Baselined("mkbhd") {
+		t.Errorf("db.Baselined: expected 'npr' and 'mkbhd' to be baselined")
+		return
+	}
+
+	// Forgetting all entries of a feed keeps it baselined; pruning
+	// it does not.
+	db.Add("npr", "1")
+	if _, err = db.Forget("npr", "1"); err != nil {
+		t.Errorf("db.Forget failed: %v", err)
+		return
+	}
+	if _, err = db.Prune([]string{"npr"}); err != nil {
+		t.Errorf("db.Prune failed: %v", err)
+		return
+	}
+	if !db.Baselined("npr") || db.Baselined("mkbhd") {
+		t.Errorf("db.Baselined: expected only 'npr' to be baselined")
+		return
+	}
+	db.Close()
+
+	// Feeds with entries in a version 2 db are baselined.
+	err = os.Remove(journalPath(dbPath))
+	if err != nil {
+		t.Errorf("Unable to remove journal: %v", err)
+		return
+	}
+	err = os.WriteFile(dbPath, []byte(`{"version":2,"downloaded":`+
+		`{"npr":[{"entry-id":"1"}],"mkbhd":[]}}`), 0644)
+	if err != nil {
+		t.Errorf("Unable to write fern-db.json: %v", err.Error())
+		return
+	}
+	db, err = Open(dbPath, false)
+	if err != nil {
+		t.Errorf("db.Open failed: %v", err.Error())
+		return
+	}
+	defer db.Close()
+	if !db.Baselined("npr") || db.Baselined("mkbhd") {
+		t.Errorf("db.Baselined: expected only 'npr' to be baselined" +
+			" after migration")
+		return
+	}
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Changes made to FernDB since it was last written to disk are
+// appended to a journal next to the db, so that they survive fern
+// being killed before Write is called. The journal is replayed on Open
+// and removed on Write.
+
+// Journal operations.
+const (
+	opAdd      = "add"      // Record added
+	opCache    = "cache"    // Feed's HTTP cache validators set
+	opFail     = "fail"     // Entry's failures set
+	opForget   = "forget"   // Entry removed
+	opDrop     = "drop"     // Feed removed
+	opBaseline = "baseline" // Feed baselined
+)
+
+// An operation in the journal. It is stored in the journal as a line
+// of JSON.
+type journalOp struct {
+	Op      string     `json:"op"`
+	Feed    string     `json:"feed"`
+	Entry   string     `json:"entry,omitempty"`   // Set for opFail and opForget
+	Record  *Record    `json:"record,omitempty"`  // Set for opAdd
+	Cache   *FeedCache `json:"cache,omitempty"`   // Set for opCache
+	Failure *Failure   `json:"failure,omitempty"` // Set for opFail
+	At      *time.Time `json:"at,omitempty"`      // Set for opBaseline
+}
+
+// Returns the path to the journal of the db at `dbPath`.
+func journalPath(dbPath string) string {
+	return dbPath + ".journal"
+}
+
+// Appends `op` to the journal and flushes it to disk. Assumes the
+// current go routine already has the mutex lock.
+func (fdb *FernDB) journalAppend(op journalOp) error {
+	if fdb.journal == nil {
+		f, err := os.OpenFile(journalPath(fdb.path),
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		fdb.journal = f
+	}
+
+	bs, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	_, err = fdb.journal.Write(append(bs, '\n'))
+	if err != nil {
+		return err
+	}
+	return fdb.journal.Sync()
+}
+
+// Replays the operations in the journal on the db. Assumes the
+// current go routine already has the mutex lock, or that the db is
+// not yet shared.
+//
+// A line that is not valid JSON is the tail of an append that was cut
+// short by a crash; it and anything after it is ignored.
+func (fdb *FernDB) journalReplay() error {
+	f, err := os.Open(journalPath(fdb.path))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		op := journalOp{}
+		err = json.Unmarshal(s.Bytes(), &op)
+		if err != nil {
+			break
+		}
+		switch {
+		case op.Op == opAdd && op.Record != nil:
+			fdb.add(op.Feed, *op.Record)
+		case op.Op == opCache && op.Cache != nil:
+			fdb.setFeedCache(op.Feed, *op.Cache)
+		case op.Op == opFail && op.Failure != nil:
+			fdb.setFailure(op.Feed, op.Entry, *op.Failure)
+		case op.Op == opForget:
+			fdb.forget(op.Feed, op.Entry)
+		case op.Op == opDrop:
+			fdb.drop(op.Feed)
+		case op.Op == opBaseline && op.At != nil:
+			fdb.baselined[op.Feed] = *op.At
+		}
+	}
+	return nil
+}
+
+// Closes and removes the journal. Meant to be called after the db is
+// written to disk. Assumes the current go routine already has the
+// mutex lock.
+func (fdb *FernDB) journalRemove() error {
+	if fdb.journal != nil {
+		fdb.journal.Close()
+		fdb.journal = nil
+	}
+	err := os.Remove(journalPath(fdb.path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
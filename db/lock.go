@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package db
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FernDB is locked by Open, so that two fern processes do not use the
+// same db at the same time. The lock is a file next to the db that
+// contains the PID of the fern process holding the lock. A lock held
+// by a process that is no longer running is stale and is taken over.
+
+// How often to check if the lock is released when waiting for it.
+var lockPollInterval = time.Second
+
+// A lock file that cannot be read is assumed to be in the middle of
+// being written, unless it is older than this.
+const lockWriteTimeout = 10 * time.Second
+
+// Returned by Open when the db is locked by another fern process.
+type LockedError struct {
+	Path string // Path to the lock file
+	Pid  int    // PID of the process holding the lock; 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.Pid == 0 {
+		return fmt.Sprintf("FernDB is locked by another fern process"+
+			" (%s)", e.Path)
+	}
+	return fmt.Sprintf("FernDB is locked by another fern process"+
+		" with PID %d (%s)", e.Pid, e.Path)
+}
+
+// Returns the path to the lock file of the db at `dbPath`.
+func lockPath(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+// Acquires the lock on the db at `dbPath`. If `wait` is true, blocks
+// until the lock is released by the process holding it; otherwise
+// returns a LockedError if the db is locked.
+func lock(dbPath string, wait bool) error {
+	waiting := false
+	for {
+		f, err := os.OpenFile(lockPath(dbPath),
+			os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+			f.Close()
+			if err != nil {
+				os.Remove(lockPath(dbPath))
+				return err
+			}
+			return nil
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+
+		// Lock is held; take it over if it is stale.
+		pid, err := lockPid(dbPath)
+		if os.IsNotExist(err) {
+			continue // Just released.
+		}
+		if err != nil {
+			pid = 0
+		}
+		if stale(dbPath, pid) {
+			ok, err := takeOver(dbPath, pid)
+			if err != nil {
+				return err
+			}
+			switch {
+			case ok && pid > 0:
+				log.Printf("Warning: took over stale FernDB lock"+
+					" held by PID %d", pid)
+				return nil
+			case ok:
+				log.Printf("Warning: took over unreadable FernDB"+
+					" lock %s", lockPath(dbPath))
+				return nil
+			}
+			// Lock changed hands, or another process is
+			// taking it over; look again in a while.
+			time.Sleep(lockPollInterval)
+			continue
+		}
+		if !wait {
+			return &LockedError{Path: lockPath(dbPath), Pid: pid}
+		}
+		switch {
+		case waiting:
+		case pid > 0:
+			log.Printf("Waiting for fern process with PID %d to"+
+				" release the FernDB lock", pid)
+		default:
+			log.Printf("Waiting for another fern process to release" +
+				" the FernDB lock")
+		}
+		waiting = true
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// Returns true if the lock on the db at `dbPath`, held by the process
+// with `pid`, is stale: the process is not running or, if `pid` is 0
+// because the lock file cannot be read, the lock file is older than
+// lockWriteTimeout.
+func stale(dbPath string, pid int) bool {
+	if pid > 0 {
+		return !processAlive(pid)
+	}
+	fi, err := os.Stat(lockPath(dbPath))
+	return err == nil && time.Since(fi.ModTime()) > lockWriteTimeout
+}
+
+// Takes over the stale lock on the db at `dbPath` held by the process
+// with `pid`; see stale.
+//
+// The lock is replaced atomically by a lock held by this process, so
+// that the lock file always exists while the lock is taken over. Only
+// one process at a time takes over the lock; it does so only if the
+// lock is still held by `pid` and is still stale.
+//
+// Returns true if the lock was taken over; false if another process
+// is taking it over or if the lock changed hands.
+func takeOver(dbPath string, pid int) (bool, error) {
+	bp := takeOverPath(dbPath)
+	f, err := os.OpenFile(bp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		// Another process is taking over the lock, or died
+		// while it was.
+		fi, err := os.Stat(bp)
+		if err == nil && time.Since(fi.ModTime()) > lockWriteTimeout {
+			os.Remove(bp)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close()
+	defer os.Remove(bp)
+
+	// Check that the lock did not change hands since it was found
+	// to be stale.
+	cur, err := lockPid(dbPath)
+	if os.IsNotExist(err) {
+		return false, nil // Released.
+	}
+	if err != nil {
+		cur = 0
+	}
+	if cur != pid || !stale(dbPath, pid) {
+		return false, nil
+	}
+
+	// Replace the lock.
+	tmp := fmt.Sprintf("%s.%d", lockPath(dbPath), os.Getpid())
+	err = os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	err = os.Rename(tmp, lockPath(dbPath))
+	if err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+
+	// Confirm that the lock is held by this process.
+	cur, err = lockPid(dbPath)
+	return err == nil && cur == os.Getpid(), nil
+}
+
+// Returns the path to the file that is created by the process taking
+// over the stale lock on the db at `dbPath`, while it does.
+func takeOverPath(dbPath string) string {
+	return lockPath(dbPath) + ".takeover"
+}
+
+// Releases the lock on the db at `dbPath`, if it is held by this
+// process.
+func unlock(dbPath string) error {
+	pid, err := lockPid(dbPath)
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	return os.Remove(lockPath(dbPath))
+}
+
+// Returns the PID in the lock file of the db at `dbPath`.
+func lockPid(dbPath string) (int, error) {
+	bs, err := os.ReadFile(lockPath(dbPath))
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(bs)))
+	if err != nil || pid < 1 {
+		return 0, fmt.Errorf("lock %s: PID invalid", lockPath(dbPath))
+	}
+	return pid, nil
+}
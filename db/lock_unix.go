@@ -0,0 +1,14 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+//go:build !windows
+
+package db
+
+import "syscall"
+
+// Returns true if a process with `pid` is running.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
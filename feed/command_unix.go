@@ -0,0 +1,28 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+//go:build !windows
+
+package feed
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// Returns a Cmd to run program `name` with `args`, bound to `ctx`.
+//
+// The program is run in its own process group, so that the signal
+// sent to fern when Ctrl-C is pressed does not reach it; fern decides
+// when to stop it. When ctx is done, the whole process group is
+// killed, so that programs spawned by it (like ffmpeg by yt-dlp) do
+// not outlive it.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return cmd
+}
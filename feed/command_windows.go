@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+//go:build windows
+
+package feed
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Returns a Cmd to run program `name` with `args`, bound to `ctx`.
+// The program is killed when ctx is done.
+func command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
@@ -0,0 +1,592 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"ricketyspace.net/fern/schema"
+)
+
+// Contains the result of downloading an entry.
+type Result struct {
+	Path   string // Path to the downloaded media; empty if unknown
+	Size   int64  // Size of the downloaded media in bytes
+	SHA256 string // Hex encoded SHA-256 of the downloaded media
+}
+
+// Downloads the media in a feed entry.
+type Downloader interface {
+	// Downloads the media at `entry`'s link into the directory
+	// `destDir`.
+	Download(ctx context.Context, entry schema.Entry,
+		destDir string) (Result, error)
+}
+
+// Default layout of a feed's media in its dump directory.
+const DefaultDirTemplate = "{feed}"
+
+// Default yt-dlp and youtube-dl output template for media, relative
+// to the feed's dump directory.
+const DefaultOutputTemplate = "%(title)s-%(id)s.%(ext)s"
+
+// Downloads media via yt-dlp.
+type YTDLPDownloader struct {
+	Path           string   // Path to the yt-dlp program.
+	Args           []string // Extra arguments to yt-dlp.
+	OutputTemplate string   // Output template; DefaultOutputTemplate if empty.
+}
+
+// Downloads media via the legacy youtube-dl program.
+type YoutubeDLDownloader struct {
+	Path           string   // Path to the youtube-dl program.
+	Args           []string // Extra arguments to youtube-dl.
+	OutputTemplate string   // Output template; DefaultOutputTemplate if empty.
+}
+
+// Downloads media directly over HTTP.
+type HTTPDownloader struct{}
+
+// Downloads media by running an external command.
+//
+// Each argument in `Args` is a template in which the following
+// placeholders are replaced before the command is run:
+//
+//	{url}   - entry's link
+//	{id}    - entry's id
+//	{title} - entry's title, with special characters removed
+//	{dir}   - directory the media must be downloaded to
+type CommandDownloader struct {
+	Args []string
+}
+
+// Returns the name of the downloader the feed uses. If 'downloader'
+// is not set for the feed, YouTube feeds are downloaded via yt-dlp
+// and all other feeds natively.
+//
+// The schema of a feed whose schema is "auto" is known only once the
+// feed is processed; until then, such a feed is taken to not be a
+// YouTube feed.
+func (feed *Feed) DownloaderName() string {
+	switch {
+	case len(feed.Downloader) > 0:
+		return feed.Downloader
+	case feed.schema() == "youtube":
+		return "yt-dlp"
+	}
+	return "native"
+}
+
+// Returns true if the feed is downloaded via yt-dlp or youtube-dl.
+func (feed *Feed) UsesYDL() bool {
+	name := feed.DownloaderName()
+	return name == "yt-dlp" || name == "youtube-dl"
+}
+
+// Returns true if the feed is, or may turn out to be, downloaded via
+// yt-dlp or youtube-dl; feeds whose schema is detected may turn out
+// to be YouTube feeds, unless 'downloader' is set.
+func (feed *Feed) MayUseYDL() bool {
+	return feed.UsesYDL() || (len(feed.Downloader) == 0 &&
+		feed.schema() == schema.Auto)
+}
+
+// Returns the feed's schema; the detected schema if the feed's schema
+// is "auto" and it was detected.
+func (feed *Feed) schema() string {
+	if feed.Schema == schema.Auto && len(feed.detected) > 0 {
+		return feed.detected
+	}
+	return feed.Schema
+}
+
+// Returns an error if the feed's downloader cannot be used: if the
+// feed is downloaded via yt-dlp or youtube-dl, like a feed detected
+// to be a YouTube feed, but 'ydl-path' is not set.
+func (feed *Feed) checkDownloader() error {
+	if !feed.UsesYDL() || len(feed.YDLPath) > 0 {
+		return nil
+	}
+	if feed.Schema == schema.Auto && len(feed.Downloader) == 0 {
+		return fmt.Errorf("feed detected to be a %s feed; 'ydl-path'"+
+			" must be set to download it via %s", feed.detected,
+			feed.DownloaderName())
+	}
+	return fmt.Errorf("'ydl-path' not set; it is needed to download"+
+		" via %s", feed.DownloaderName())
+}
+
+// Returns the Downloader for the feed.
+func (feed *Feed) downloader() (Downloader, error) {
+	switch feed.DownloaderName() {
+	case "yt-dlp":
+		return &YTDLPDownloader{
+			Path:           feed.YDLPath,
+			Args:           feed.YDLArgs,
+			OutputTemplate: feed.OutputTemplate,
+		}, nil
+	case "youtube-dl":
+		return &YoutubeDLDownloader{
+			Path:           feed.YDLPath,
+			Args:           feed.YDLArgs,
+			OutputTemplate: feed.OutputTemplate,
+		}, nil
+	case "native":
+		return &HTTPDownloader{}, nil
+	case "command":
+		if len(feed.Command) == 0 {
+			return nil, fmt.Errorf("'command' not set for the" +
+				" 'command' downloader")
+		}
+		return &CommandDownloader{Args: feed.Command}, nil
+	}
+	return nil, fmt.Errorf("'downloader' '%s' is not valid; must be one"+
+		" of yt-dlp, youtube-dl, native, command", feed.Downloader)
+}
+
+// Returns the directory that entry is downloaded to: the feed's
+// 'dir-template', or DefaultDirTemplate if it is not set, expanded for
+// entry in the feed's dump directory.
+//
+// Entries without a publication time are downloaded to an "undated"
+// directory in place of the template's first path element that has a
+// date placeholder and the elements after it, so that they always go
+// to the same directory.
+func (feed *Feed) entryDir(entry schema.Entry) string {
+	tmpl := feed.DirTemplate
+	if len(tmpl) == 0 {
+		tmpl = DefaultDirTemplate
+	}
+	t := entry.PubTime
+	if t.IsZero() {
+		elems := strings.Split(tmpl, "/")
+		for i, elem := range elems {
+			if strings.Contains(elem, "{year}") ||
+				strings.Contains(elem, "{month}") ||
+				strings.Contains(elem, "{day}") {
+				elems = append(elems[:i], "undated")
+				break
+			}
+		}
+		tmpl = strings.Join(elems, "/")
+	}
+	r := strings.NewReplacer(
+		"{feed}", feed.Id,
+		"{year}", t.Format("2006"),
+		"{month}", t.Format("01"),
+		"{day}", t.Format("02"),
+	)
+	return path.Join(feed.DumpDir, r.Replace(tmpl))
+}
+
+// Downloads entry via the feed's downloader.
+func (feed *Feed) download(ctx context.Context,
+	entry schema.Entry) (Result, error) {
+	dl, err := feed.downloader()
+	if err != nil {
+		return Result{}, err
+	}
+	destDir := feed.entryDir(entry)
+	err = os.MkdirAll(destDir, 0755)
+	if err != nil {
+		return Result{}, err
+	}
+	result, err := dl.Download(ctx, entry, destDir)
+	if err != nil {
+		return result, err
+	}
+	if msg := sizeMismatch(entry, result); len(msg) > 0 {
+		fmt.Printf("[%s][%s]: Warning: %s\n", feed.Id, entry.Id, msg)
+	}
+
+	// Checksum the media, if its location is known.
+	if len(result.Path) > 0 && len(result.SHA256) == 0 {
+		result.SHA256, err = fileSHA256(result.Path)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Returns a warning if the size of the media downloaded for `entry`
+// differs from the entry's enclosure length; "" if it does not or if
+// either is unknown. A mismatch does not fail the download, as feeds
+// often get the length wrong, but may point to a truncated download
+// when the server does not give the media's size.
+func sizeMismatch(entry schema.Entry, result Result) string {
+	if entry.Length <= 0 || result.Size <= 0 ||
+		result.Size == entry.Length {
+		return ""
+	}
+	return fmt.Sprintf("got %d bytes, but the feed gives the media's"+
+		" length as %d", result.Size, entry.Length)
+}
+
+// Returns the hex encoded SHA-256 of the file at `name`.
+func fileSHA256(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Downloads the media at the entry's link directly over HTTP.
+//
+// The media is streamed to a partial file in `destDir` and moved into
+// place only after it is fully downloaded and its size verified
+// against the size the server gives. If a partial file is left behind
+// by an earlier, interrupted download, the download is resumed from
+// where it stopped via a HTTP Range request; the request is
+// conditional on the media not having changed since, if the server
+// gave a validator for it.
+//
+// The entry's enclosure length is not relied on, as feeds often get
+// it wrong; a mismatch is only warned about, see sizeMismatch.
+func (d *HTTPDownloader) Download(ctx context.Context, entry schema.Entry,
+	destDir string) (Result, error) {
+	result := Result{}
+	if len(entry.Link) == 0 {
+		return result, fmt.Errorf("URL invalid")
+	}
+
+	// Resume from the end of the partial file, if it exists.
+	partPath := path.Join(destDir, partName(entry))
+	offset := int64(0)
+	ifRange := ""
+	if fi, err := os.Stat(partPath); err == nil {
+		offset = fi.Size()
+		if bs, err := os.ReadFile(ifRangePath(partPath)); err == nil {
+			ifRange = string(bs)
+		}
+	}
+
+	resp, err := d.get(ctx, entry.Link, offset, ifRange)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// Partial file is not usable; start over.
+		resp.Body.Close()
+		offset = 0
+		resp, err = d.get(ctx, entry.Link, offset, "")
+		if err != nil {
+			return result, err
+		}
+		defer resp.Body.Close()
+	}
+
+	// Figure out where to write and the expected size of the
+	// media.
+	size := int64(-1)
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, total, err := parseContentRange(
+			resp.Header.Get("Content-Range"))
+		if err != nil {
+			return result, err
+		}
+		if start != offset {
+			return result, fmt.Errorf("GET %s: resumed at %d, "+
+				"expected %d", entry.Link, start, offset)
+		}
+		size = total
+	case http.StatusOK:
+		// Server sends the whole media; start over.
+		offset = 0
+		if resp.ContentLength >= 0 {
+			size = resp.ContentLength
+		}
+		// Keep the media's validator to resume the download
+		// with, in case it is interrupted.
+		err = setIfRange(partPath, resp)
+		if err != nil {
+			return result, err
+		}
+	default:
+		return result, newHTTPError(resp)
+	}
+
+	// Open partial file and drop anything past offset.
+	part, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return result, err
+	}
+	defer part.Close()
+	err = part.Truncate(offset)
+	if err != nil {
+		return result, err
+	}
+	_, err = part.Seek(offset, io.SeekStart)
+	if err != nil {
+		return result, err
+	}
+
+	// Stream media to partial file. On error, the partial file is
+	// left as is so that the download can be resumed later.
+	n, err := io.Copy(part, resp.Body)
+	if err != nil {
+		return result, err
+	}
+	result.Size = offset + n
+	if size >= 0 && result.Size < size {
+		return Result{}, fmt.Errorf("GET %s: %w: got %d bytes,"+
+			" expected %d", entry.Link, errIncomplete,
+			result.Size, size)
+	}
+	if size >= 0 && result.Size > size {
+		// Not resumable.
+		part.Close()
+		os.Remove(partPath)
+		os.Remove(ifRangePath(partPath))
+		return Result{}, fmt.Errorf("GET %s: got %d bytes, expected %d",
+			entry.Link, result.Size, size)
+	}
+	err = part.Sync()
+	if err != nil {
+		return Result{}, err
+	}
+	err = part.Close()
+	if err != nil {
+		return Result{}, err
+	}
+
+	// Name the media after the entry's title and the last
+	// element of the (possibly redirected) URL's path.
+	mediaName := fmt.Sprintf("%s-%s",
+		specialCharReplacer.Replace(entry.Title),
+		path.Base(resp.Request.URL.Path))
+	result.Path = path.Join(destDir, mediaName)
+	err = os.Rename(partPath, result.Path)
+	if err != nil {
+		return Result{}, err
+	}
+	os.Remove(ifRangePath(partPath))
+	return result, nil
+}
+
+// Sends a GET request for url. If offset is greater than 0, requests
+// the content from offset onwards; if `ifRange` is set too, only if
+// the content's validator is `ifRange`, the whole content otherwise.
+func (d *HTTPDownloader) get(ctx context.Context, url string,
+	offset int64, ifRange string) (*http.Response, error) {
+	req, err := newRequest(url)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if len(ifRange) > 0 {
+			req.Header.Set("If-Range", ifRange)
+		}
+	}
+	client := http.Client{}
+	return client.Do(req.WithContext(ctx))
+}
+
+// Returns the path to the file that holds the validator of the media
+// being downloaded to the partial file `partPath`.
+func ifRangePath(partPath string) string {
+	return partPath + ".if-range"
+}
+
+// Stores the validator of the media in `resp` for resuming its
+// download to the partial file `partPath`: the media's ETag if it is
+// strong, its Last-Modified otherwise. The stored validator is
+// removed if `resp` has neither.
+func setIfRange(partPath string, resp *http.Response) error {
+	v := resp.Header.Get("ETag")
+	if len(v) == 0 || strings.HasPrefix(v, "W/") {
+		v = resp.Header.Get("Last-Modified")
+	}
+	if len(v) == 0 {
+		err := os.Remove(ifRangePath(partPath))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(ifRangePath(partPath), []byte(v), 0644)
+}
+
+// Returns the name of the partial file that entry is downloaded to.
+// The name depends only on the entry's id, so that an interrupted
+// download is picked up again by the next run.
+func partName(entry schema.Entry) string {
+	sum := sha256.Sum256([]byte(entry.Id))
+	return fmt.Sprintf(".fern-%x.part", sum[:8])
+}
+
+// Parses a Content-Range header of the form "bytes start-end/total".
+//
+// Returns start and total; total is -1 if unknown.
+func parseContentRange(cr string) (int64, int64, error) {
+	var start, end int64
+	var total string
+	_, err := fmt.Sscanf(cr, "bytes %d-%d/%s", &start, &end, &total)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Content-Range '%s' invalid", cr)
+	}
+	if total == "*" {
+		return start, -1, nil
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Content-Range '%s' invalid", cr)
+	}
+	return start, size, nil
+}
+
+// Downloads entry via yt-dlp.
+func (d *YTDLPDownloader) Download(ctx context.Context, entry schema.Entry,
+	destDir string) (Result, error) {
+	result := Result{}
+	if len(entry.Link) == 0 {
+		return result, fmt.Errorf("URL invalid")
+	}
+
+	// Have yt-dlp print the path to the media once it is in
+	// place.
+	args := []string{"--no-progress", "--print", "after_move:filepath"}
+	args = append(args, d.Args...)
+	args = append(args, ydlOutputTemplate(d.OutputTemplate, destDir),
+		entry.Link)
+	cmd := command(ctx, d.Path, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return result, commandError(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	result.Path = lines[len(lines)-1]
+	if fi, err := os.Stat(result.Path); err == nil {
+		result.Size = fi.Size()
+	}
+	return result, nil
+}
+
+// Downloads entry via youtube-dl.
+func (d *YoutubeDLDownloader) Download(ctx context.Context, entry schema.Entry,
+	destDir string) (Result, error) {
+	result := Result{}
+	if len(entry.Link) == 0 {
+		return result, fmt.Errorf("URL invalid")
+	}
+
+	args := append([]string{"--no-progress"}, d.Args...)
+	args = append(args, ydlOutputTemplate(d.OutputTemplate, destDir),
+		entry.Link)
+	cmd := command(ctx, d.Path, args...)
+	_, err := cmd.Output()
+	if err != nil {
+		return result, commandError(err)
+	}
+	return result, nil
+}
+
+// Downloads entry by running the external command.
+func (d *CommandDownloader) Download(ctx context.Context, entry schema.Entry,
+	destDir string) (Result, error) {
+	result := Result{}
+	if len(entry.Link) == 0 {
+		return result, fmt.Errorf("URL invalid")
+	}
+	if len(d.Args) == 0 {
+		return result, fmt.Errorf("command not set")
+	}
+
+	r := strings.NewReplacer(
+		"{url}", entry.Link,
+		"{id}", entry.Id,
+		"{title}", specialCharReplacer.Replace(entry.Title),
+		"{dir}", destDir,
+	)
+	args := make([]string, 0, len(d.Args))
+	for _, arg := range d.Args {
+		args = append(args, r.Replace(arg))
+	}
+	cmd := command(ctx, args[0], args[1:]...)
+	_, err := cmd.Output()
+	if err != nil {
+		return result, commandError(err)
+	}
+	return result, nil
+}
+
+// Returns the youtube-dl/yt-dlp output template option for the
+// output template `tmpl` in `destDir`; DefaultOutputTemplate is used
+// if `tmpl` is empty.
+func ydlOutputTemplate(tmpl, destDir string) string {
+	if len(tmpl) == 0 {
+		tmpl = DefaultOutputTemplate
+	}
+	return fmt.Sprintf("-o%s", path.Join(destDir, tmpl))
+}
+
+// Checks that the yt-dlp/youtube-dl output template `tmpl` stays in
+// the directory it is relative to. An empty `tmpl` is valid.
+func CheckOutputTemplate(tmpl string) error {
+	if len(tmpl) == 0 {
+		return nil
+	}
+	c := path.Clean(tmpl)
+	if path.IsAbs(c) || c == ".." || strings.HasPrefix(c, "../") {
+		return fmt.Errorf("'%s' must be relative to the dump"+
+			" directory", tmpl)
+	}
+	return nil
+}
+
+// Checks that the directory template `tmpl` stays in the directory it
+// is relative to and has only the placeholders {feed}, {year}, {month}
+// and {day}. An empty `tmpl` is valid.
+func CheckDirTemplate(tmpl string) error {
+	if len(tmpl) == 0 {
+		return nil
+	}
+	c := path.Clean(tmpl)
+	if path.IsAbs(c) || c == ".." || strings.HasPrefix(c, "../") {
+		return fmt.Errorf("'%s' must be relative to the dump"+
+			" directory", tmpl)
+	}
+	rest := strings.NewReplacer("{feed}", "", "{year}", "", "{month}", "",
+		"{day}", "").Replace(tmpl)
+	if strings.ContainsAny(rest, "{}") {
+		return fmt.Errorf("'%s' has an unknown placeholder; must be"+
+			" one of {feed}, {year}, {month}, {day}", tmpl)
+	}
+	return nil
+}
+
+// Adds the last line the command wrote to stderr, if any, to err.
+func commandError(err error) error {
+	ee, ok := err.(*exec.ExitError)
+	if !ok {
+		return err
+	}
+	stderr := strings.TrimSpace(string(ee.Stderr))
+	if len(stderr) == 0 {
+		return err
+	}
+	lines := strings.Split(stderr, "\n")
+	return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+}
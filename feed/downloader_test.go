@@ -0,0 +1,562 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"ricketyspace.net/fern/file"
+	"ricketyspace.net/fern/schema"
+	"ricketyspace.net/fern/version"
+)
+
+func TestHTTPDownload(t *testing.T) {
+	media := []byte("not really an mp3")
+	ua := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ep", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/media/ep-42.mp3", http.StatusFound)
+	})
+	mux.HandleFunc("/media/ep-42.mp3", func(w http.ResponseWriter, r *http.Request) {
+		ua = r.Header.Get("User-Agent")
+		w.Write(media)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	feed := new(Feed)
+	feed.Schema = "podcast"
+	feed.DumpDir = t.TempDir()
+	entry := schema.Entry{
+		Id:    "42",
+		Title: "Episode 42: The Answer",
+		Link:  ts.URL + "/ep",
+	}
+	result, err := feed.download(context.Background(), entry)
+	if err != nil {
+		t.Errorf("download: %v", err)
+		return
+	}
+	if ua != "fern/"+version.Version {
+		t.Errorf("user agent: %s", ua)
+		return
+	}
+	mediaPath := path.Join(feed.DumpDir, "Episode_42_The_Answer-ep-42.mp3")
+	if result.Path != mediaPath {
+		t.Errorf("result path: %s != %s", result.Path, mediaPath)
+		return
+	}
+	if result.Size != int64(len(media)) {
+		t.Errorf("result size: %d != %d", result.Size, len(media))
+		return
+	}
+	sum := sha256.Sum256(media)
+	if result.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("result sha256: %s", result.SHA256)
+		return
+	}
+	bs, err := file.ReadFile(mediaPath)
+	if err != nil {
+		t.Errorf("read media: %v", err)
+		return
+	}
+	if !bytes.Equal(bs, media) {
+		t.Errorf("media content: '%s' != '%s'", bs, media)
+		return
+	}
+
+	// Only the media must be left in the dump directory.
+	des, err := os.ReadDir(feed.DumpDir)
+	if err != nil {
+		t.Errorf("read dump dir: %v", err)
+		return
+	}
+	if len(des) != 1 {
+		t.Errorf("dump dir: expected 1 file, got %d", len(des))
+		return
+	}
+}
+
+func TestHTTPDownloadNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	feed := new(Feed)
+	feed.Schema = "npr"
+	feed.DumpDir = t.TempDir()
+	entry := schema.Entry{
+		Id:    "42",
+		Title: "Missing",
+		Link:  ts.URL + "/missing.mp3",
+	}
+	if _, err := feed.download(context.Background(), entry); err == nil {
+		t.Errorf("download: expected error for 404")
+		return
+	}
+	des, err := os.ReadDir(feed.DumpDir)
+	if err != nil {
+		t.Errorf("read dump dir: %v", err)
+		return
+	}
+	if len(des) != 0 {
+		t.Errorf("dump dir: expected no files, got %d", len(des))
+		return
+	}
+}
+
+func TestCommandDownload(t *testing.T) {
+	src := path.Join(t.TempDir(), "src.txt")
+	err := os.WriteFile(src, []byte("42"), 0644)
+	if err != nil {
+		t.Errorf("write source: %v", err)
+		return
+	}
+
+	feed := new(Feed)
+	feed.Schema = "podcast"
+	feed.Downloader = "command"
+	feed.Command = []string{"cp", "{url}", "{dir}/{id}-{title}.txt"}
+	feed.DumpDir = t.TempDir()
+	entry := schema.Entry{
+		Id:    "42",
+		Title: "The (Answer)",
+		Link:  src,
+	}
+	if _, err := feed.download(context.Background(), entry); err != nil {
+		t.Errorf("download: %v", err)
+		return
+	}
+	bs, err := file.ReadFile(path.Join(feed.DumpDir, "42-The_Answer.txt"))
+	if err != nil {
+		t.Errorf("read media: %v", err)
+		return
+	}
+	if string(bs) != "42" {
+		t.Errorf("media content: '%s' != '42'", bs)
+		return
+	}
+}
+
+func TestYTDLPDownload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+
+	// Fake yt-dlp that records its arguments and prints the path
+	// to the media.
+	dir := t.TempDir()
+	argsPath := path.Join(dir, "args.txt")
+	mediaPath := path.Join(dir, "42.mp3")
+	ydl := path.Join(dir, "yt-dlp")
+	script := fmt.Sprintf("#!/bin/sh\nprintf '%%s\\n' \"$@\" > %s\n"+
+		"printf 42 > %s\necho %s\n", argsPath, mediaPath, mediaPath)
+	err := os.WriteFile(ydl, []byte(script), 0755)
+	if err != nil {
+		t.Errorf("write yt-dlp: %v", err)
+		return
+	}
+
+	feed := new(Feed)
+	feed.Schema = "youtube"
+	feed.YDLPath = ydl
+	feed.YDLArgs = []string{"-f", "bestaudio"}
+	feed.OutputTemplate = "%(id)s.%(ext)s"
+	feed.DumpDir = dir
+	entry := schema.Entry{Id: "42", Link: "https://example.com/watch?v=42"}
+	result, err := feed.download(context.Background(), entry)
+	if err != nil {
+		t.Errorf("download: %v", err)
+		return
+	}
+	if result.Path != mediaPath || result.Size != 2 {
+		t.Errorf("result: %+v", result)
+		return
+	}
+	bs, err := file.ReadFile(argsPath)
+	if err != nil {
+		t.Errorf("read args: %v", err)
+		return
+	}
+	expected := "--no-progress\n--print\nafter_move:filepath\n-f\n" +
+		"bestaudio\n-o" + dir + "/%(id)s.%(ext)s\n" + entry.Link + "\n"
+	if string(bs) != expected {
+		t.Errorf("args: '%s' != '%s'", bs, expected)
+		return
+	}
+
+	// Default output template.
+	if o := ydlOutputTemplate("", dir); o !=
+		"-o"+path.Join(dir, DefaultOutputTemplate) {
+		t.Errorf("default output template: %s", o)
+		return
+	}
+}
+
+func TestEntryDir(t *testing.T) {
+	feed := Feed{Id: "pc", DumpDir: "/media"}
+	entry := schema.Entry{
+		PubTime: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		tmpl     string
+		expected string
+	}{
+		{"", "/media/pc"},
+		{"{feed}/{year}/{month}", "/media/pc/2024/03"},
+		{"{year}-{month}-{day}", "/media/2024-03-09"},
+		{".", "/media"},
+	}
+	for _, test := range tests {
+		feed.DirTemplate = test.tmpl
+		if err := CheckDirTemplate(test.tmpl); err != nil {
+			t.Errorf("check '%s': %v", test.tmpl, err)
+			return
+		}
+		dir := feed.entryDir(entry)
+		if dir != test.expected {
+			t.Errorf("entry dir '%s': %s != %s", test.tmpl, dir,
+				test.expected)
+			return
+		}
+	}
+
+	// Undated entry.
+	tests = []struct {
+		tmpl     string
+		expected string
+	}{
+		{"", "/media/pc"},
+		{"{feed}/{year}/{month}", "/media/pc/undated"},
+		{"{year}-{month}-{day}", "/media/undated"},
+		{"{feed}/{year}-{month}/{feed}", "/media/pc/undated"},
+	}
+	for _, test := range tests {
+		feed.DirTemplate = test.tmpl
+		dir := feed.entryDir(schema.Entry{})
+		if dir != test.expected {
+			t.Errorf("undated entry dir '%s': %s != %s", test.tmpl,
+				dir, test.expected)
+			return
+		}
+	}
+
+	for _, tmpl := range []string{"/{feed}", "../{feed}", "{feed}/{week}"} {
+		if err := CheckDirTemplate(tmpl); err == nil {
+			t.Errorf("check '%s': expected error", tmpl)
+			return
+		}
+	}
+}
+
+func TestDownloader(t *testing.T) {
+	tests := []struct {
+		schema     string
+		downloader string
+		expected   string
+	}{
+		{"youtube", "", "*feed.YTDLPDownloader"},
+		{"podcast", "", "*feed.HTTPDownloader"},
+		{"npr", "", "*feed.HTTPDownloader"},
+		{"youtube", "youtube-dl", "*feed.YoutubeDLDownloader"},
+		{"podcast", "yt-dlp", "*feed.YTDLPDownloader"},
+		{"youtube", "command", "*feed.CommandDownloader"},
+	}
+	for _, test := range tests {
+		feed := new(Feed)
+		feed.Schema = test.schema
+		feed.Downloader = test.downloader
+		feed.Command = []string{"true"}
+		dl, err := feed.downloader()
+		if err != nil {
+			t.Errorf("downloader: %v", err)
+			return
+		}
+		if fmt.Sprintf("%T", dl) != test.expected {
+			t.Errorf("downloader: %T != %s", dl, test.expected)
+			return
+		}
+	}
+
+	// Feed detected to be a YouTube feed.
+	bs, err := os.ReadFile("testdata/yt-channel.xml")
+	if err != nil {
+		t.Errorf("read: %v", err)
+		return
+	}
+	feed := new(Feed)
+	feed.Schema = "auto"
+	if feed.UsesYDL() || !feed.MayUseYDL() {
+		t.Errorf("auto: expected to maybe use ydl before detection")
+		return
+	}
+	err = feed.unmarshal(bs)
+	if err != nil {
+		t.Errorf("unmarshal: %v", err)
+		return
+	}
+	dl, err := feed.downloader()
+	if err != nil {
+		t.Errorf("downloader: %v", err)
+		return
+	}
+	if _, ok := dl.(*YTDLPDownloader); !ok || !feed.UsesYDL() {
+		t.Errorf("auto: expected yt-dlp for a YouTube feed: %T", dl)
+		return
+	}
+	err = feed.checkDownloader()
+	if err == nil || !strings.Contains(err.Error(), "'ydl-path' must be set") {
+		t.Errorf("auto: expected error without 'ydl-path': %v", err)
+		return
+	}
+	feed.YDLPath = "/usr/bin/yt-dlp"
+	if err := feed.checkDownloader(); err != nil {
+		t.Errorf("auto: %v", err)
+		return
+	}
+	feed.Downloader = "native"
+	if _, err := feed.downloader(); err != nil || feed.MayUseYDL() {
+		t.Errorf("auto: expected native: %v", err)
+		return
+	}
+
+	feed = new(Feed)
+	feed.Schema = "podcast"
+	feed.Downloader = "wget"
+	if _, err := feed.downloader(); err == nil {
+		t.Errorf("downloader: expected error for 'wget'")
+		return
+	}
+	feed.Downloader = "command"
+	feed.Command = nil
+	if _, err := feed.downloader(); err == nil {
+		t.Errorf("downloader: expected error for empty 'command'")
+		return
+	}
+}
+
+func TestHTTPDownloadResume(t *testing.T) {
+	media := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	etag := `"v1"`
+	rangeHdr := ""
+	ifRangeHdr := ""
+	cut := false // Cut the response short
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			rangeHdr = r.Header.Get("Range")
+			ifRangeHdr = r.Header.Get("If-Range")
+			w.Header().Set("ETag", etag)
+			if cut {
+				w.Header().Set("Content-Length",
+					fmt.Sprint(len(media)))
+				w.Write(media[:10])
+				return
+			}
+			http.ServeContent(w, r, "ep.mp3", time.Time{},
+				bytes.NewReader(media))
+		}))
+	defer ts.Close()
+
+	dumpDir := t.TempDir()
+	entry := schema.Entry{
+		Id:    "resume-42",
+		Title: "Resume",
+		Link:  ts.URL + "/ep.mp3",
+	}
+
+	// Leave a partial file behind, as an interrupted download
+	// would.
+	partPath := path.Join(dumpDir, partName(entry))
+	err := os.WriteFile(partPath, media[:10], 0644)
+	if err != nil {
+		t.Errorf("write partial file: %v", err)
+		return
+	}
+
+	d := new(HTTPDownloader)
+	result, err := d.Download(context.Background(), entry, dumpDir)
+	if err != nil {
+		t.Errorf("download: %v", err)
+		return
+	}
+	if rangeHdr != "bytes=10-" {
+		t.Errorf("range header: '%s'", rangeHdr)
+		return
+	}
+	bs, err := file.ReadFile(result.Path)
+	if err != nil {
+		t.Errorf("read media: %v", err)
+		return
+	}
+	if !bytes.Equal(bs, media) {
+		t.Errorf("media content: '%s' != '%s'", bs, media)
+		return
+	}
+	if _, err := os.Stat(partPath); err == nil {
+		t.Errorf("partial file exists after download")
+		return
+	}
+
+	// Interrupted download of media that changed since; the
+	// partial file must not be resumed.
+	err = os.WriteFile(partPath, media[:10], 0644)
+	if err != nil {
+		t.Errorf("write partial file: %v", err)
+		return
+	}
+	err = os.WriteFile(ifRangePath(partPath), []byte(`"v0"`), 0644)
+	if err != nil {
+		t.Errorf("write if-range file: %v", err)
+		return
+	}
+	media = []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	result, err = d.Download(context.Background(), entry, dumpDir)
+	if err != nil {
+		t.Errorf("download: %v", err)
+		return
+	}
+	if ifRangeHdr != `"v0"` {
+		t.Errorf("if-range header: '%s'", ifRangeHdr)
+		return
+	}
+	bs, err = file.ReadFile(result.Path)
+	if err != nil {
+		t.Errorf("read media: %v", err)
+		return
+	}
+	if !bytes.Equal(bs, media) {
+		t.Errorf("media content: '%s' != '%s'", bs, media)
+		return
+	}
+	if _, err := os.Stat(ifRangePath(partPath)); err == nil {
+		t.Errorf("if-range file exists after download")
+		return
+	}
+
+	// Validator of the media is kept for resuming an interrupted
+	// download.
+	cut = true
+	_, err = d.Download(context.Background(), entry, dumpDir)
+	if err == nil {
+		t.Errorf("download: expected error for interrupted download")
+		return
+	}
+	bs, err = os.ReadFile(ifRangePath(partPath))
+	if err != nil || string(bs) != etag {
+		t.Errorf("if-range file: '%s': %v", bs, err)
+		return
+	}
+}
+
+func TestHTTPDownloadIncomplete(t *testing.T) {
+	media := []byte("0123456789")
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Promise more than is sent.
+			w.Header().Set("Content-Length", "20")
+			w.Write(media)
+		}))
+	defer ts.Close()
+
+	dumpDir := t.TempDir()
+	entry := schema.Entry{
+		Id:    "incomplete-42",
+		Title: "Incomplete",
+		Link:  ts.URL + "/ep.mp3",
+	}
+	d := new(HTTPDownloader)
+	_, err := d.Download(context.Background(), entry, dumpDir)
+	if err == nil {
+		t.Errorf("download: expected error for incomplete media")
+		return
+	}
+
+	// Partial file must be kept to resume the download later.
+	bs, err := file.ReadFile(path.Join(dumpDir, partName(entry)))
+	if err != nil {
+		t.Errorf("read partial file: %v", err)
+		return
+	}
+	if !bytes.Equal(bs, media) {
+		t.Errorf("partial content: '%s' != '%s'", bs, media)
+		return
+	}
+}
+
+func TestHTTPDownloadEnclosureLength(t *testing.T) {
+	media := []byte("0123456789")
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Flush before writing, so that the response is
+			// chunked and has no Content-Length.
+			w.(http.Flusher).Flush()
+			w.Write(media)
+		}))
+	defer ts.Close()
+
+	// Enclosure length, too large or too small, must not fail the
+	// download, but is warned about.
+	for _, length := range []int64{20, 5, 10, 0} {
+		entry := schema.Entry{
+			Id:     fmt.Sprintf("length-%d", length),
+			Title:  "Length",
+			Link:   ts.URL + "/ep.mp3",
+			Length: length,
+		}
+		d := new(HTTPDownloader)
+		result, err := d.Download(context.Background(), entry,
+			t.TempDir())
+		if err != nil {
+			t.Errorf("download: length %d: %v", length, err)
+			return
+		}
+		if result.Size != int64(len(media)) {
+			t.Errorf("download: length %d: size %d", length,
+				result.Size)
+			return
+		}
+		mismatch := length > 0 && length != result.Size
+		if msg := sizeMismatch(entry, result); (len(msg) > 0) != mismatch {
+			t.Errorf("download: length %d: mismatch '%s'", length,
+				msg)
+			return
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		cr    string
+		start int64
+		total int64
+		ok    bool
+	}{
+		{"bytes 10-35/36", 10, 36, true},
+		{"bytes 0-99/*", 0, -1, true},
+		{"bytes */36", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, total, err := parseContentRange(test.cr)
+		if (err == nil) != test.ok {
+			t.Errorf("parse '%s': %v", test.cr, err)
+			return
+		}
+		if start != test.start || total != test.total {
+			t.Errorf("parse '%s': %d, %d", test.cr, start, total)
+			return
+		}
+	}
+}
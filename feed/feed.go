@@ -4,32 +4,52 @@
 package feed
 
 import (
-	"encoding/xml"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
-	"path"
+	"slices"
 	"strings"
 	"time"
 
+	"ricketyspace.net/fern/db"
 	"ricketyspace.net/fern/schema"
 	"ricketyspace.net/fern/state"
 	"ricketyspace.net/fern/version"
 )
 
 type Feed struct {
-	Id            string
-	Source        string
-	Schema        string
-	Last          int
-	TitleContains string `json:"title-contains"`
-	YDLPath       string
-	DumpDir       string
-	Entries       []schema.Entry
+	Id             string
+	Source         string
+	Schema         string
+	Last           int
+	TitleContains  string            `json:"title-contains"`
+	Tags           []string          `json:"tags"`            // For running a subset of the feeds
+	Baseline       bool              `json:"baseline"`        // Mark entries as seen, instead of downloading them, when the feed is first seen
+	Downloader     string            `json:"downloader"`      // "yt-dlp", "youtube-dl", "native" or "command"
+	Command        []string          `json:"command"`         // Command template for the "command" downloader
+	Retry          *RetryPolicy      `json:"retry"`           // Overrides the retry policy in the config
+	Quarantine     *QuarantinePolicy `json:"quarantine"`      // Overrides the quarantine policy in the config
+	YDLPath        string            `json:"ydl-path"`        // Overrides 'ydl-path' in the config
+	YDLArgs        []string          `json:"ydl-args"`        // Overrides 'ydl-args' in the config
+	OutputTemplate string            `json:"output-template"` // Overrides 'output-template' in the config
+	DumpDir        string            `json:"dump-dir"`        // Overrides 'dump-dir' in the config
+	DirTemplate    string            `json:"dir-template"`    // Overrides 'dir-template' in the config
+	Entries        []schema.Entry    `json:"-"`               // Set when the feed is processed
+	detected       string            // Schema detected from the feed, if Schema is "auto"; set when the feed is processed
 }
 
+// Returned for entries that were not downloaded because fern is
+// shutting down.
+var errShutdown = errors.New("fern is shutting down")
+
+// Returned when a feed did not change since it was last requested.
+var errNotModified = errors.New("feed not modified")
+
 var specialCharReplacer = strings.NewReplacer(
 	"'", "",
 	"’", "",
@@ -43,82 +63,242 @@ var specialCharReplacer = strings.NewReplacer(
 	" ", "_",
 )
 
-func (feed *Feed) Validate(baseDumpDir string) error {
-	_, err := os.Stat(baseDumpDir)
-	if err != nil {
-		return err
-	}
+// A problem with a field of a feed; returned by Check.
+type FieldError struct {
+	Field string // Field's key in the config, like "last"
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Returns a FieldError for `field` with a message formatted like
+// fmt.Errorf.
+func fieldError(field, format string, a ...any) *FieldError {
+	return &FieldError{Field: field, Err: fmt.Errorf(format, a...)}
+}
+
+// Checks the feed's fields, without side effects.
+//
+// Returns the problems found, as FieldErrors; nil if there are none.
+func (feed *Feed) Check() []error {
+	errs := []error{}
 
 	// Check 'id'
 	if len(feed.Id) == 0 {
-		return fmt.Errorf("'id' not set in a feed")
+		errs = append(errs, fieldError("id", "'id' not set"))
 	}
 
 	// Check 'source'
 	if len(feed.Source) == 0 {
-		return fmt.Errorf("'source' not set in a feed '%s'", feed.Id)
+		errs = append(errs, fieldError("source", "'source' not set"))
+	} else if u, err := url.Parse(feed.Source); err != nil ||
+		(u.Scheme != "http" && u.Scheme != "https") {
+		errs = append(errs, fieldError("source",
+			"'source' '%s' is not a HTTP URL", feed.Source))
 	}
 
 	// Check 'schema'
-	schemaOK := false
-	for _, schema := range []string{"npr", "youtube", "podcast"} {
-		if feed.Schema == schema {
-			schemaOK = true
-		}
-	}
-	if !schemaOK {
-		return fmt.Errorf("schema '%s' for feed '%s' is not valid",
-			feed.Schema, feed.Id)
+	if _, ok := schema.Lookup(feed.Schema); !ok && feed.Schema != schema.Auto {
+		errs = append(errs, fieldError("schema", "'schema' '%s' is"+
+			" not valid; must be one of %s, %s", feed.Schema,
+			strings.Join(schema.Names(), ", "), schema.Auto))
 	}
 
 	// Check 'last'
 	if feed.Last < 1 {
-		return fmt.Errorf("'last' not set or 0 in a feed '%s'", feed.Id)
+		errs = append(errs, fieldError("last", "'last' not set or 0"))
 	}
 
-	// Set dump directory for feed and ensure it exists.
-	feed.DumpDir = path.Join(baseDumpDir, feed.Id)
-	err = os.MkdirAll(feed.DumpDir, 0755)
+	// Check 'tags'
+	for _, tag := range feed.Tags {
+		if len(strings.TrimSpace(tag)) == 0 {
+			errs = append(errs, fieldError("tags", "empty tag in 'tags'"))
+		}
+	}
+
+	// Check 'downloader'
+	_, err := feed.downloader()
 	if err != nil {
-		return err
+		errs = append(errs, &FieldError{Field: "downloader", Err: err})
 	}
 
-	return nil
+	// Check 'dir-template'
+	err = CheckDirTemplate(feed.DirTemplate)
+	if err != nil {
+		errs = append(errs, fieldError("dir-template",
+			"'dir-template' %v", err))
+	}
+
+	// Check 'output-template'
+	err = CheckOutputTemplate(feed.OutputTemplate)
+	if err != nil {
+		errs = append(errs, fieldError("output-template",
+			"'output-template' %v", err))
+	}
+
+	// Check 'retry'
+	err = feed.retryPolicy().Validate()
+	if err != nil {
+		errs = append(errs, fieldError("retry", "'retry' not valid: %v",
+			err))
+	}
+
+	// Check 'quarantine'
+	err = feed.quarantinePolicy().Validate()
+	if err != nil {
+		errs = append(errs, fieldError("quarantine",
+			"'quarantine' not valid: %v", err))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
 }
 
-// Get the feed.
-func (feed *Feed) get() ([]byte, error) {
-	// Init byte container to store feed content.
-	bs := make([]byte, 0)
+// Validates the feed and ensures its dump directory exists.
+//
+// Returns nil if validation succeeds; error otherwise.
+func (feed *Feed) Validate() error {
+	errs := feed.Check()
+	if len(feed.DumpDir) == 0 {
+		errs = append(errs, fieldError("dump-dir", "'dump-dir' not set"))
+	}
+	if len(errs) > 0 {
+		if len(feed.Id) == 0 {
+			return fmt.Errorf("feed: %w", errors.Join(errs...))
+		}
+		return fmt.Errorf("feed '%s': %w", feed.Id, errors.Join(errs...))
+	}
 
-	req, err := http.NewRequest("GET", feed.Source, nil)
+	// Ensure dump directory exists.
+	return os.MkdirAll(feed.DumpDir, 0755)
+}
+
+// Returns a GET request for `url` with fern's User-Agent set.
+func newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return bs, err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", "fern/"+version.Version)
+	return req, nil
+}
+
+// Returned when a HTTP request gets a response that fern cannot use.
+type HTTPError struct {
+	URL         string // Requested URL
+	StatusCode  int    // Response's status code
+	Status      string // Response's status
+	ContentType string // Response's content type
+	Snippet     string // Beginning of the response's body
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("GET %s: %s", e.URL, e.Status)
+	if e.StatusCode >= 200 && e.StatusCode < 300 {
+		msg = fmt.Sprintf("GET %s: unexpected content type '%s'",
+			e.URL, e.ContentType)
+	}
+	if len(e.Snippet) > 0 {
+		msg += fmt.Sprintf(": %q", e.Snippet)
+	}
+	return msg
+}
+
+// Maximum length of HTTPError.Snippet.
+const snippetLen = 128
+
+// Returns a HTTPError for `resp`. Reads up to snippetLen bytes of the
+// response's body for the error's snippet.
+func newHTTPError(resp *http.Response) *HTTPError {
+	bs, _ := io.ReadAll(io.LimitReader(resp.Body, snippetLen))
+	return &HTTPError{
+		URL:         resp.Request.URL.String(),
+		StatusCode:  resp.StatusCode,
+		Status:      resp.Status,
+		ContentType: resp.Header.Get("Content-Type"),
+		Snippet:     strings.Join(strings.Fields(string(bs)), " "),
+	}
+}
+
+// Returns the feed's settings that decide which of its entries are
+// downloaded. HTTP cache validators stored for the feed are used only
+// as long as these settings do not change.
+func (feed *Feed) settings() string {
+	return fmt.Sprintf("last=%d title-contains=%s", feed.Last,
+		feed.TitleContains)
+}
+
+// Get the feed.
+//
+// If `fc` has HTTP cache validators, the feed is requested
+// conditionally; errNotModified is returned if the feed did not change
+// since. Along with the feed, returns the validators to use for the
+// next request.
+//
+// Returns a HTTPError if the server responds with a non-2xx status or
+// with a HTML page.
+func (feed *Feed) get(ctx context.Context,
+	fc db.FeedCache) ([]byte, db.FeedCache, error) {
+	req, err := newRequest(feed.Source)
+	if err != nil {
+		return nil, fc, err
+	}
+	if fc.Settings == feed.settings() {
+		if len(fc.ETag) > 0 {
+			req.Header.Set("If-None-Match", fc.ETag)
+		}
+		if len(fc.LastModified) > 0 {
+			req.Header.Set("If-Modified-Since", fc.LastModified)
+		}
+	}
 	client := http.Client{}
-	resp, err := client.Do(req)
+	resp, err := client.Do(req.WithContext(ctx))
 	if err != nil {
-		return bs, err
+		return nil, fc, err
+	}
+	defer resp.Body.Close()
+
+	// Check response.
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, fc, errNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fc, newHTTPError(resp)
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt == "text/html" {
+		return nil, fc, newHTTPError(resp)
 	}
 
 	// Slurp body.
-	chunk := make([]byte, 100)
-	for {
-		c, err := resp.Body.Read(chunk)
-		if c < 1 {
-			break
-		}
-		if err != nil && err != io.EOF {
-			return bs, err
-		}
-		bs = append(bs, chunk[0:c]...)
+	bs, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fc, err
+	}
+	nfc := db.FeedCache{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
 	}
-	return bs, nil
+	if nfc != (db.FeedCache{}) {
+		nfc.Settings = feed.settings()
+	}
+	return bs, nfc, nil
 }
 
 // Processes the feed.
-func (feed *Feed) Process(pState *state.ProcessState) {
+//
+// Once pState.Shutdown is closed, no more entries are downloaded;
+// downloads in progress are allowed to finish unless `ctx` is
+// cancelled.
+func (feed *Feed) Process(ctx context.Context, pState *state.ProcessState) {
 	// Init FeedResult.
 	fr := state.FeedResult{
 		FeedId:     feed.Id,
@@ -126,20 +306,65 @@ func (feed *Feed) Process(pState *state.ProcessState) {
 		Err:        nil,
 	}
 
-	// Get raw feed.
-	bs, err := feed.get()
+	// Get feed; skip it if it did not change since it was last
+	// processed, unless quarantined entries in it are to be tried
+	// again.
+	cache := pState.DB.FeedCache(feed.Id)
+	if pState.RetryFailed ||
+		feed.cooledDown(pState.DB, cache.Processed, time.Now()) {
+		cache = db.FeedCache{}
+	}
+	release, ok := pState.Limiter.AcquireFeed(pState.Shutdown)
+	if !ok {
+		fr.FeedResult = "Processing interrupted"
+		pState.FeedResultChan <- fr
+		return
+	}
+	fc, err := feed.fetch(ctx, cache, pState.Shutdown)
+	release()
+	if err == errNotModified {
+		fr.FeedResult = "Feed unchanged"
+		pState.FeedResultChan <- fr
+		return
+	}
 	if err != nil {
 		fr.Err = err
 		fr.FeedResult = "Unable to get feed"
+		switch err.(type) {
+		case *HTTPError:
+			fr.FeedResult = "Feed request failed"
+		case *parseError:
+			fr.FeedResult = "Unable to parse feed"
+		}
+		pState.FeedResultChan <- fr
+		return
+	}
+
+	// Mark the entries of a feed that was not seen before as seen,
+	// instead of downloading them, if the feed is baselined.
+	if feed.needsBaseline(pState.DB) {
+		n, err := feed.markSeen(pState.DB)
+		if err != nil {
+			fmt.Printf("[%s]: Unable to journal entries marked as"+
+				" seen: %v\n", feed.Id, err.Error())
+		}
+		err = pState.DB.SetFeedCache(feed.Id, fc)
+		if err != nil {
+			fmt.Printf("[%s]: Unable to journal cache validators:"+
+				" %v\n", feed.Id, err.Error())
+		}
+		fr.FeedResult = fmt.Sprintf("First time the feed is seen;"+
+			" marked %d entries as seen without downloading them", n)
 		pState.FeedResultChan <- fr
 		return
 	}
 
-	// Unmarshal raw feed into Feed.Object
-	err = feed.unmarshal(bs)
+	// Fail the feed if its entries cannot be downloaded, like a
+	// feed detected to be a YouTube feed when yt-dlp is not set up.
+	err = feed.checkDownloader()
 	if err != nil {
 		fr.Err = err
-		fr.FeedResult = "Unable to parse feed"
+		fr.FeedResult = "Unable to download entries"
 		pState.FeedResultChan <- fr
 		return
 	}
@@ -148,40 +373,39 @@ func (feed *Feed) Process(pState *state.ProcessState) {
 	// Process entries.
 	//
 	// Number entries being processed.
-	errors := 0
+	failed := 0
+	interrupted := 0
+	quarantined := 0
 	processing := 0
-	traversed := 0
 	// Channel for receiving entry results.
 	erChan := make(chan state.EntryResult)
-	// Simple semaphore to limit the number of concurrent
-	// processEntry calls.
-	// https://go.dev/doc/effective_go#channels
-	eSem := make(chan int, 10)
-	for _, entry := range feed.Entries {
-		e := entry
-
-		// Ignore entry if its title does not matches
-		// feed's 'title-contains' string.
-		if len(feed.TitleContains) > 0 &&
-			!e.TitleContains(feed.TitleContains) {
-			fmt.Printf("[%s][%s]: Skipping '%s'\n",
-				feed.Id, e.Id, e.Title)
-			continue
-		}
-
-		// Process entry only if it was not downloaded before.
-		if !pState.DB.Exists(feed.Id, e.Id) {
-			go feed.processEntry(e, erChan, eSem)
+	now := time.Now()
+schedule:
+	for _, pe := range feed.plan(pState.DB, pState.RetryFailed, now) {
+		e := pe.Entry
+		switch {
+		case pe.Action == Download:
+			// Stop scheduling entries if fern is shutting
+			// down.
+			if pState.ShuttingDown() {
+				interrupted += 1
+				break schedule
+			}
+			go feed.processEntry(ctx, e, erChan, pState.Limiter,
+				pState.Shutdown)
 			processing += 1
-		} else {
+		case pe.Action == Present:
 			fmt.Printf("[%s][%s]: Already downloaded '%s' before\n",
 				feed.Id, e.Id, e.Title)
-		}
-		traversed += 1
-
-		// Process only `feed.Last` entries.
-		if traversed >= feed.Last {
-			break
+		case pe.Reason == SkipTitle:
+			fmt.Printf("[%s][%s]: Skipping '%s'\n",
+				feed.Id, e.Id, e.Title)
+		case pe.Reason == SkipQuarantined:
+			fmt.Printf("[%s][%s]: Skipping '%s' until %s; it failed"+
+				" to download %d times: %s\n", feed.Id, e.Id,
+				e.Title, feed.QuarantinedUntil(pe.Failure).Local().Format(time.RFC3339),
+				pe.Failure.Count, pe.Failure.LastError)
+			quarantined += 1
 		}
 	}
 	// Wait for all entries to finish processing.
@@ -193,32 +417,84 @@ func (feed *Feed) Process(pState *state.ProcessState) {
 		fmt.Printf("[%s]: Waiting for %d %s to finish processing\n",
 			feed.Id, processing, eTxt)
 		er := <-erChan
-		if er.Err == nil {
-			fmt.Printf("[%s][%s]: Downloaded '%s'\n",
-				feed.Id, er.EntryId, er.EntryTitle)
+		switch {
+		case er.Err == nil:
+			fmt.Printf("[%s][%s]: Downloaded '%s'%s\n",
+				feed.Id, er.EntryId, er.EntryTitle,
+				attemptsTxt(er.Attempts))
 			// Log entry in db.
-			pState.DB.Add(feed.Id, er.EntryId)
-		} else {
-			fmt.Printf("[%s][%s]: Failed to download '%s': %v\n",
+			err = pState.DB.AddRecord(feed.Id, er.Record)
+			if err != nil {
+				fmt.Printf("[%s][%s]: Unable to journal '%s': %v\n",
+					feed.Id, er.EntryId, er.EntryTitle,
+					err.Error())
+			}
+		case er.Err == errShutdown:
+			fmt.Printf("[%s][%s]: Not downloading '%s': %v\n",
 				feed.Id, er.EntryId, er.EntryTitle,
 				er.Err.Error())
-			errors += 1
+			interrupted += 1
+		default:
+			fmt.Printf("[%s][%s]: Failed to download '%s'%s: %v\n",
+				feed.Id, er.EntryId, er.EntryTitle,
+				attemptsTxt(er.Attempts), er.Err.Error())
+			failed += 1
+			if ctx.Err() != nil {
+				break // Download was stopped; not its fault.
+			}
+			// Log failure in db.
+			err = pState.DB.AddFailure(feed.Id, er.EntryId, er.Err)
+			if err != nil {
+				fmt.Printf("[%s][%s]: Unable to journal failure"+
+					" of '%s': %v\n", feed.Id, er.EntryId,
+					er.EntryTitle, err.Error())
+			}
+			f := pState.DB.Failure(feed.Id, er.EntryId)
+			if feed.Quarantined(f) {
+				fmt.Printf("[%s][%s]: Quarantined '%s' until %s\n",
+					feed.Id, er.EntryId, er.EntryTitle,
+					feed.QuarantinedUntil(f).Local().Format(time.RFC3339))
+			}
 		}
 		processing -= 1
 	}
-	if errors == 0 {
-		fr.FeedResult = "Processed feed"
-	} else {
+	// Keep the feed's cache validators only if all of its entries
+	// were processed, so that the entries that were not are tried
+	// again next time even if the feed does not change. Quarantined
+	// entries are tried again once their cooldown ends; see
+	// cooledDown.
+	if failed > 0 || interrupted > 0 {
+		fc = db.FeedCache{}
+	}
+	if fc != (db.FeedCache{}) {
+		fc.Processed = now.UTC()
+	}
+	err = pState.DB.SetFeedCache(feed.Id, fc)
+	if err != nil {
+		fmt.Printf("[%s]: Unable to journal cache validators: %v\n",
+			feed.Id, err.Error())
+	}
+	switch {
+	case failed > 0:
 		fr.FeedResult = "Processed feed. One or more" +
 			" entries failed to download"
+	case interrupted > 0:
+		fr.FeedResult = "Processing interrupted"
+	case quarantined > 0:
+		fr.FeedResult = "Processed feed. One or more" +
+			" entries were skipped after failing repeatedly"
+	default:
+		fr.FeedResult = "Processed feed"
 	}
 	pState.FeedResultChan <- fr
 }
 
-func (feed *Feed) processEntry(entry schema.Entry, erc chan state.EntryResult,
-	sema chan int) {
-	sema <- 1 // Wait for semaphore.
-
+// Downloads entry once `limiter` lets it, unless `shutdown` is closed
+// before that; a failed attempt is not retried once `shutdown` is
+// closed.
+func (feed *Feed) processEntry(ctx context.Context, entry schema.Entry,
+	erc chan state.EntryResult, limiter *state.Limiter,
+	shutdown chan struct{}) {
 	// Init EntryResult.
 	er := state.EntryResult{
 		EntryId:    entry.Id,
@@ -226,157 +502,134 @@ func (feed *Feed) processEntry(entry schema.Entry, erc chan state.EntryResult,
 		Err:        nil,
 	}
 
+	// Wait for the limiter; no download is started once fern is
+	// shutting down.
+	release, ok := limiter.AcquireDownload(entry.Link, shutdown)
+	if ok {
+		select {
+		case <-shutdown:
+			release()
+			ok = false
+		default:
+		}
+	}
+	if !ok {
+		er.Err = errShutdown
+		erc <- er
+		return
+	}
+
 	// Download entry.
 	fmt.Printf("[%s][%s] Going to download '%s'\n", feed.Id,
 		entry.Id, entry.Title)
-	err := feed.ydl(entry)
+	var result Result
+	attempts, err := feed.retryPolicy().do(ctx, shutdown, func() error {
+		var err error
+		result, err = feed.download(ctx, entry)
+		return err
+	}, func(attempt int, wait time.Duration, err error) {
+		fmt.Printf("[%s][%s]: Attempt %d to download '%s' failed:"+
+			" %v; retrying in %v\n", feed.Id, entry.Id, attempt,
+			entry.Title, err.Error(), wait.Round(time.Millisecond))
+	})
+	er.Attempts = attempts
 	if err != nil {
 		er.Err = err
+	} else {
+		er.Record = db.Record{
+			EntryId: entry.Id,
+			Title:   entry.Title,
+			PubTime: entry.PubTime,
+			Link:    entry.Link,
+			File:    result.Path,
+			Bytes:   result.Size,
+			SHA256:  result.SHA256,
+		}
 	}
 	erc <- er
 
-	<-sema // Give up token.
+	release()
 }
 
-func (feed *Feed) ydl(entry schema.Entry) error {
-	if len(entry.Link) == 0 {
-		return fmt.Errorf("URL invalid")
-	}
+// Returns true if the feed is tagged with `tag`.
+func (feed *Feed) HasTag(tag string) bool {
+	return slices.Contains(feed.Tags, tag)
+}
 
-	// Media file name.
-	mediaName := "%(title)s-%(id)s.%(ext)s"
-	switch {
-	case strings.Contains(entry.Link, "buzzsprout.com"):
-		mediaName = path.Base(entry.Link)
-	case strings.Contains(entry.Link, "megaphone.fm"):
-		mediaName = fmt.Sprintf(
-			"%s-%%(id)s.%%(ext)s",
-			specialCharReplacer.Replace(entry.Title),
-		)
-	}
-
-	// Download url via youtube-dl
-	outputTemplate := fmt.Sprintf("-o%s",
-		path.Join(feed.DumpDir, mediaName))
-	cmd := exec.Command(feed.YDLPath, "--no-progress", outputTemplate, entry.Link)
-	_, err := cmd.CombinedOutput()
-	if err != nil {
-		return err
-	}
-	return nil
+// Returns the feed's retry policy.
+func (feed *Feed) retryPolicy() RetryPolicy {
+	return DefaultRetryPolicy.Override(feed.Retry)
 }
 
-// Unmarshal raw feed into an object.
-func (feed *Feed) unmarshal(bs []byte) error {
-	var err error
+// Returns the feed's quarantine policy.
+func (feed *Feed) quarantinePolicy() QuarantinePolicy {
+	return DefaultQuarantinePolicy.Override(feed.Quarantine)
+}
 
-	// Unmarshal based on feed's schema type.
-	switch {
-	case feed.Schema == "npr":
-		feed.Entries, err = nprUnmarshal(bs)
-		if err != nil {
-			return err
-		}
-		return nil
-	case feed.Schema == "youtube":
-		feed.Entries, err = youtubeUnmarshal(bs)
-		if err != nil {
-			return err
-		}
-		return nil
-	case feed.Schema == "podcast":
-		feed.Entries, err = podcastUnmarshal(bs)
-		if err != nil {
-			return err
-		}
-		return nil
-	}
-	return fmt.Errorf("schema of feed '%s' unknown", feed.Id)
+// Returns true if an entry of the feed that has failed `f` is
+// quarantined.
+func (feed *Feed) Quarantined(f db.Failure) bool {
+	return feed.quarantinePolicy().quarantined(f, time.Now())
 }
 
-// Unmarshal a NPR feed.
-func nprUnmarshal(bs []byte) ([]schema.Entry, error) {
-	nprFeed := new(schema.NPRFeed)
-	err := xml.Unmarshal(bs, nprFeed)
-	if err != nil {
-		return nil, err
-	}
+// Returns the time until which an entry of the feed that has failed
+// `f` is quarantined.
+func (feed *Feed) QuarantinedUntil(f db.Failure) time.Time {
+	return feed.quarantinePolicy().until(f)
+}
 
-	// Get all entries.
-	entries := make([]schema.Entry, 0)
-	for _, e := range nprFeed.Entries {
-		t, err := time.Parse(time.RFC1123Z, e.Pub)
-		if err != nil {
-			return nil, err
+// Returns true if the quarantine of one or more of the feed's entries
+// in `fdb` ended after `since`, when the feed was last processed, and
+// by `now`; the feed must be processed again for those entries to be
+// tried again, even if it did not change.
+func (feed *Feed) cooledDown(fdb *db.FernDB, since, now time.Time) bool {
+	qPolicy := feed.quarantinePolicy()
+	if qPolicy.After < 1 {
+		return false
+	}
+	for _, f := range fdb.Failures(feed.Id) {
+		if f.Count < qPolicy.After {
+			continue
 		}
-		entry := schema.Entry{
-			Id:      e.Id,
-			Title:   e.Title,
-			PubTime: t,
-			Link:    e.Link.Url,
+		until := qPolicy.until(f)
+		if until.After(since) && !until.After(now) {
+			return true
 		}
-		entries = append(entries, entry)
 	}
-	return entries, nil
+	return false
 }
 
-// Unmarshal a YouTube feed.
-func youtubeUnmarshal(bs []byte) ([]schema.Entry, error) {
-	ytFeed := new(schema.YouTubeFeed)
-	err := xml.Unmarshal(bs, ytFeed)
-	if err != nil {
-		return nil, err
+// Returns " after N attempts" if `attempts` is more than 1; an empty
+// string otherwise.
+func attemptsTxt(attempts int) string {
+	if attempts < 2 {
+		return ""
 	}
+	return fmt.Sprintf(" after %d attempts", attempts)
+}
 
-	// Get all entries.
-	entries := make([]schema.Entry, 0)
-	for _, e := range ytFeed.Entries {
-		t, err := time.Parse(time.RFC3339, e.Pub)
+// Unmarshal raw feed into an object.
+func (feed *Feed) unmarshal(bs []byte) error {
+	var err error
+
+	// Detect schema, if it is to be detected.
+	name := feed.Schema
+	if name == schema.Auto {
+		name, err = schema.Detect(bs)
 		if err != nil {
-			return nil, err
-		}
-		entry := schema.Entry{
-			Id:      e.Id,
-			Title:   e.Title,
-			PubTime: t,
-			Link:    e.Link.Url,
+			return err
 		}
-		entries = append(entries, entry)
+		feed.detected = name
 	}
-	return entries, nil
-}
 
-// Unmarshal a Podcast feed.
-func podcastUnmarshal(bs []byte) ([]schema.Entry, error) {
-	pcFeed := new(schema.PodcastFeed)
-	err := xml.Unmarshal(bs, pcFeed)
-	if err != nil {
-		return nil, err
+	// Unmarshal with the schema's parser.
+	parser, ok := schema.Lookup(name)
+	if !ok {
+		return fmt.Errorf("schema of feed '%s' unknown", feed.Id)
 	}
-
-	// Get all entries.
-	entries := make([]schema.Entry, 0)
-	for _, e := range pcFeed.Entries {
-		layout := time.RFC1123Z
-		if e.Pub[len(e.Pub)-1:] == "T" {
-			// Textual time zone. like 'EDT'.
-			if e.Pub[6:7] == " " {
-				layout = "Mon, 2 Jan 2006 15:04:05 MST"
-			} else {
-				layout = time.RFC1123
-			}
-		}
-		t, err := time.Parse(layout, e.Pub)
-		if err != nil {
-			return nil, err
-		}
-		entry := schema.Entry{
-			Id:      e.Id,
-			Title:   e.Title,
-			PubTime: t,
-			Link:    e.Link.Url,
-		}
-		entries = append(entries, entry)
+	feed.Entries, err = parser.Parse(bs)
+	if err != nil {
+		return err
 	}
-	return entries, nil
+	return nil
 }
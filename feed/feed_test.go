@@ -4,31 +4,54 @@
 package feed
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"path"
+	"strings"
 	"testing"
+	"time"
 
+	"ricketyspace.net/fern/db"
 	"ricketyspace.net/fern/file"
+	"ricketyspace.net/fern/schema"
+	"ricketyspace.net/fern/state"
 )
 
-func TestPodcastUnmarshal(t *testing.T) {
-	testFeeds := []string{
-		"testdata/pc-atp.xml",
-		"testdata/pc-daringfireball.xml",
-		"testdata/pc-kara.xml",
-		"testdata/pc-scwpod.xml",
+func TestUnmarshal(t *testing.T) {
+	testFeeds := []struct {
+		schema string
+		feed   string
+	}{
+		{"podcast", "testdata/pc-daringfireball.xml"},
+		{"rss", "testdata/pc-atp.xml"},
+		{"rss", "testdata/rss-generic.xml"},
+		{"atom", "testdata/atom-generic.xml"},
+		{"youtube", "testdata/yt-channel.xml"},
+		{"auto", "testdata/pc-kara.xml"},
+		{"auto", "testdata/yt-channel.xml"},
+		{"jsonfeed", "testdata/jf-podcast.json"},
+		{"auto", "testdata/jf-blog.json"},
 	}
-	for _, feed := range testFeeds {
-		bs, err := file.ReadFile(feed)
+	for _, test := range testFeeds {
+		bs, err := file.ReadFile(test.feed)
 		if err != nil {
 			t.Errorf("read feed: %v", err)
 			return
 		}
-		entries, err := podcastUnmarshal(bs)
-		if err != nil {
-			t.Errorf("feed unmarshal: %v", err)
+		feed := new(Feed)
+		feed.Schema = test.schema
+		if err = feed.unmarshal(bs); err != nil {
+			t.Errorf("feed unmarshal: %s: %v", test.feed, err)
 			return
 		}
-		for _, entry := range entries {
+		if len(feed.Entries) < 1 {
+			t.Errorf("feed unmarshal: %s: no entries", test.feed)
+			return
+		}
+		for _, entry := range feed.Entries {
 			if len(entry.Id) < 1 {
 				t.Errorf("entry id: %v", entry.Id)
 				return
@@ -50,40 +73,504 @@ func TestPodcastUnmarshal(t *testing.T) {
 	}
 }
 
-func TestUnmarshal(t *testing.T) {
-	testFeeds := map[string]string{
-		"podcast": "testdata/pc-daringfireball.xml",
+func TestGenericUnmarshal(t *testing.T) {
+	tests := []struct {
+		feed    string
+		entries []schema.Entry
+	}{
+		{
+			"testdata/rss-generic.xml",
+			[]schema.Entry{
+				{
+					Id:      "fr-003",
+					Title:   "Rain on a Tin Roof",
+					PubTime: time.Date(2024, 3, 5, 7, 30, 0, 0, time.UTC),
+					Link:    "https://cdn.example.com/fr/rain.ogg",
+					Length:  4194304,
+				},
+				{
+					Id:      "fr-002",
+					Title:   "Harbour at Dawn",
+					PubTime: time.Date(2024, 2, 26, 5, 0, 0, 0, time.UTC),
+					Link:    "https://cdn.example.com/fr/harbour.ogg",
+				},
+				{
+					Id:      "https://example.com/night-market",
+					Title:   "Night Market",
+					PubTime: time.Date(2024, 2, 18, 2, 15, 0, 0, time.UTC),
+					Link:    "https://example.com/night-market",
+				},
+			},
+		},
+		{
+			"testdata/atom-generic.xml",
+			[]schema.Entry{
+				{
+					Id:      "urn:uuid:0f3c4e7a-1d0e-4b65-8a5f-6a9c9b1f2e03",
+					Title:   "Rain on a Tin Roof",
+					PubTime: time.Date(2024, 3, 5, 7, 30, 0, 0, time.UTC),
+					Link:    "https://cdn.example.com/fr/rain.ogg",
+					Length:  4194304,
+				},
+				{
+					Id:      "urn:uuid:0f3c4e7a-1d0e-4b65-8a5f-6a9c9b1f2e02",
+					Title:   "Harbour at Dawn",
+					PubTime: time.Date(2024, 2, 26, 5, 0, 0, 0, time.UTC),
+					Link:    "https://example.com/harbour",
+				},
+			},
+		},
 	}
-	for schema, feed := range testFeeds {
-		bs, err := file.ReadFile(feed)
+	for _, test := range tests {
+		bs, err := file.ReadFile(test.feed)
 		if err != nil {
 			t.Errorf("read feed: %v", err)
 			return
 		}
 		feed := new(Feed)
-		feed.Schema = schema
+		feed.Schema = "auto"
 		if err = feed.unmarshal(bs); err != nil {
-			t.Errorf("feed unmarshal: %v", err)
+			t.Errorf("feed unmarshal: %s: %v", test.feed, err)
 			return
 		}
-		for _, entry := range feed.Entries {
-			if len(entry.Id) < 1 {
-				t.Errorf("entry id: %v", entry.Id)
-				return
-			}
-			if len(entry.Title) < 1 {
-				t.Errorf("entry title: %v", entry.Title)
-				return
-			}
-			if entry.PubTime.Unix() < 994702392 {
-				t.Errorf("entry time: %v", entry.PubTime)
+		if len(feed.Entries) != len(test.entries) {
+			t.Errorf("%s: entries: %d != %d", test.feed,
+				len(feed.Entries), len(test.entries))
+			return
+		}
+		for i, entry := range feed.Entries {
+			expected := test.entries[i]
+			if entry.Id != expected.Id || entry.Title != expected.Title ||
+				!entry.PubTime.Equal(expected.PubTime) ||
+				entry.Link != expected.Link ||
+				entry.Length != expected.Length {
+				t.Errorf("%s: entry %d: %+v != %+v", test.feed, i,
+					entry, expected)
 				return
 			}
-			_, err = url.Parse(entry.Link)
-			if err != nil {
-				t.Errorf("entry link: %s: %v", entry.Link, err)
+		}
+	}
+}
+
+func TestProcessEntryShutdown(t *testing.T) {
+	feed := new(Feed)
+	feed.Id = "npr"
+	feed.Schema = "npr"
+	feed.DumpDir = t.TempDir()
+	entry := schema.Entry{
+		Id:    "42",
+		Title: "The Answer",
+		Link:  "http://127.0.0.1:1/42.mp3",
+	}
+
+	// All download slots are taken and fern is shutting down;
+	// entry must not be downloaded.
+	pState := state.NewProcessState()
+	pState.Limiter = state.NewLimiter(state.Limits{MaxDownloads: 1})
+	release, _ := pState.Limiter.AcquireDownload(entry.Link, nil)
+	defer release()
+	pState.StartShutdown()
+	erc := make(chan state.EntryResult, 1)
+	feed.processEntry(context.Background(), entry, erc, pState.Limiter,
+		pState.Shutdown)
+	er := <-erc
+	if er.Err != errShutdown {
+		t.Errorf("processEntry: expected errShutdown, got: %v", er.Err)
+		return
+	}
+	if er.EntryId != entry.Id {
+		t.Errorf("processEntry: entry id: %s", er.EntryId)
+		return
+	}
+}
+
+func TestGet(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte("<rss></rss>"))
+	})
+	mux.HandleFunc("/feed.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/feed+json")
+		w.Write([]byte(`{"version": "https://jsonfeed.org/version/1.1"}`))
+	})
+	mux.HandleFunc("/gone.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html>\n  <h1>Not Found</h1>\n</html>"))
+	})
+	mux.HandleFunc("/login.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><h1>Login</h1></html>"))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	feed := new(Feed)
+	feed.Source = ts.URL + "/feed.xml"
+	bs, _, err := feed.get(context.Background(), db.FeedCache{})
+	if err != nil {
+		t.Errorf("get: %v", err)
+		return
+	}
+	if string(bs) != "<rss></rss>" {
+		t.Errorf("get: '%s'", bs)
+		return
+	}
+
+	// JSON Feed.
+	feed.Source = ts.URL + "/feed.json"
+	_, _, err = feed.get(context.Background(), db.FeedCache{})
+	if err != nil {
+		t.Errorf("get: %v", err)
+		return
+	}
+
+	// Not found.
+	feed.Source = ts.URL + "/gone.xml"
+	_, _, err = feed.get(context.Background(), db.FeedCache{})
+	he, ok := err.(*HTTPError)
+	if !ok {
+		t.Errorf("get: expected HTTPError, got: %v", err)
+		return
+	}
+	if he.StatusCode != http.StatusNotFound || he.URL != feed.Source ||
+		he.Snippet != "<html> <h1>Not Found</h1> </html>" {
+		t.Errorf("get: unexpected HTTPError: %#v", he)
+		return
+	}
+
+	// HTML instead of a feed.
+	feed.Source = ts.URL + "/login.xml"
+	_, _, err = feed.get(context.Background(), db.FeedCache{})
+	he, ok = err.(*HTTPError)
+	if !ok {
+		t.Errorf("get: expected HTTPError, got: %v", err)
+		return
+	}
+	if he.StatusCode != http.StatusOK {
+		t.Errorf("get: unexpected HTTPError: %#v", he)
+		return
+	}
+}
+
+func TestGetConditional(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests += 1
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
 				return
 			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write([]byte("<rss></rss>"))
+		}))
+	defer ts.Close()
+
+	feed := new(Feed)
+	feed.Source = ts.URL + "/feed.xml"
+	feed.Last = 5
+	_, fc, err := feed.get(context.Background(), db.FeedCache{})
+	if err != nil {
+		t.Errorf("get: %v", err)
+		return
+	}
+	if fc.ETag != `"v1"` || fc.Settings != feed.settings() {
+		t.Errorf("get: unexpected cache validators: %v", fc)
+		return
+	}
+
+	// Feed did not change.
+	_, nfc, err := feed.get(context.Background(), fc)
+	if err != errNotModified {
+		t.Errorf("get: expected errNotModified, got: %v", err)
+		return
+	}
+	if nfc != fc {
+		t.Errorf("get: cache validators changed: %v", nfc)
+		return
+	}
+
+	// Feed's settings changed; validators must not be used.
+	feed.Last = 10
+	bs, _, err := feed.get(context.Background(), fc)
+	if err != nil {
+		t.Errorf("get: %v", err)
+		return
+	}
+	if string(bs) != "<rss></rss>" {
+		t.Errorf("get: '%s'", bs)
+		return
+	}
+	if requests != 3 {
+		t.Errorf("get: expected 3 requests, got %d", requests)
+		return
+	}
+}
+
+// Returns a podcast feed with an entry for each of `ids`; the media of
+// each entry is at `/<id>.mp3` on `base`.
+func testPodcast(base string, ids ...string) string {
+	items := ""
+	for _, id := range ids {
+		items += fmt.Sprintf("<item><guid>%s</guid><title>Episode %s</title>"+
+			"<pubDate>Tue, 22 Nov 2022 10:00:00 +0000</pubDate>"+
+			"<enclosure url=\"%s/%s.mp3\" type=\"audio/mpeg\"/></item>\n",
+			id, id, base, id)
+	}
+	return "<?xml version=\"1.0\"?>\n<rss version=\"2.0\"><channel>" +
+		"<title>T</title>\n" + items + "</channel></rss>"
+}
+
+func TestProcess(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(testPodcast(ts.URL, "a", "b", "c")))
+	})
+	for _, id := range []string{"a", "c"} {
+		mux.HandleFunc("/"+id+".mp3", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("media"))
+		})
+	}
+
+	fdb, err := db.Open(path.Join(t.TempDir(), "db.json"), false)
+	if err != nil {
+		t.Errorf("db.Open: %v", err)
+		return
+	}
+	defer fdb.Close()
+	pState := state.NewProcessState()
+	pState.DB = fdb
+
+	feed := Feed{
+		Id:         "pc",
+		Source:     ts.URL + "/feed.xml",
+		Schema:     "podcast",
+		Last:       2,
+		Retry:      &RetryPolicy{MaxAttempts: 1},
+		Quarantine: &QuarantinePolicy{After: 1},
+		DumpDir:    t.TempDir(),
+	}
+	err = feed.Validate()
+	if err != nil {
+		t.Errorf("validate: %v", err)
+		return
+	}
+
+	// First run; 'b' fails and 'c' is not among the last 2.
+	go feed.Process(context.Background(), pState)
+	fr := <-pState.FeedResultChan
+	if fr.Err != nil || !strings.Contains(fr.FeedResult, "failed") {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
+	}
+	if !fdb.Exists("pc", "a") || fdb.Exists("pc", "b") ||
+		fdb.Exists("pc", "c") {
+		t.Errorf("process: expected only 'a' to be downloaded")
+		return
+	}
+	if fdb.Failure("pc", "b").Count != 1 {
+		t.Errorf("process: expected failure of 'b' to be recorded")
+		return
+	}
+
+	// Second run; 'b' is quarantined.
+	go feed.Process(context.Background(), pState)
+	fr = <-pState.FeedResultChan
+	if fr.Err != nil || !strings.Contains(fr.FeedResult, "skipped") {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
+	}
+	if fdb.Failure("pc", "b").Count != 1 {
+		t.Errorf("process: expected 'b' to not be tried again")
+		return
+	}
+
+	// Baselined feed that is seen for the first time.
+	feed.Id = "pc-new"
+	feed.Baseline = true
+	go feed.Process(context.Background(), pState)
+	fr = <-pState.FeedResultChan
+	if fr.Err != nil {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if !fdb.Exists("pc-new", id) {
+			t.Errorf("process: expected '%s' to be marked as seen", id)
+			return
+		}
+	}
+	if r := fdb.Records("pc-new")[0]; !r.Baseline || len(r.File) > 0 {
+		t.Errorf("process: unexpected baseline record: %v", r)
+		return
+	}
+}
+
+func TestProcessBaseline(t *testing.T) {
+	ids := []string{}
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(testPodcast(ts.URL, ids...)))
+	})
+	mux.HandleFunc("/a.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media"))
+	})
+
+	fdb, err := db.Open(path.Join(t.TempDir(), "db.json"), false)
+	if err != nil {
+		t.Errorf("db.Open: %v", err)
+		return
+	}
+	defer fdb.Close()
+	pState := state.NewProcessState()
+	pState.DB = fdb
+
+	feed := Feed{
+		Id:       "pc",
+		Source:   ts.URL + "/feed.xml",
+		Schema:   "podcast",
+		Last:     2,
+		Baseline: true,
+		DumpDir:  t.TempDir(),
+	}
+	err = feed.Validate()
+	if err != nil {
+		t.Errorf("validate: %v", err)
+		return
+	}
+	process := func() state.FeedResult {
+		go feed.Process(context.Background(), pState)
+		return <-pState.FeedResultChan
+	}
+
+	// First fetch has no entries; the feed is baselined
+	// nevertheless.
+	fr := process()
+	if fr.Err != nil || !strings.Contains(fr.FeedResult, "marked 0") {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
+	}
+
+	// First episode is downloaded.
+	ids = []string{"a"}
+	process()
+	if r := fdb.Records("pc"); len(r) != 1 || r[0].Baseline ||
+		len(r[0].File) == 0 {
+		t.Errorf("process: expected 'a' to be downloaded: %v", r)
+		return
+	}
+
+	// Forgotten episode is downloaded again, instead of the feed
+	// being baselined again.
+	if _, err = fdb.Forget("pc", "a"); err != nil {
+		t.Errorf("forget: %v", err)
+		return
+	}
+	process()
+	if r := fdb.Records("pc"); len(r) != 1 || r[0].Baseline {
+		t.Errorf("process: expected 'a' to be downloaded again: %v", r)
+		return
+	}
+}
+
+func TestProcessQuarantineCache(t *testing.T) {
+	bOK := false
+	requests := 0
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testPodcast(ts.URL, "a", "b")))
+	})
+	mux.HandleFunc("/a.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media"))
+	})
+	mux.HandleFunc("/b.mp3", func(w http.ResponseWriter, r *http.Request) {
+		if !bOK {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("media"))
+	})
+
+	fdb, err := db.Open(path.Join(t.TempDir(), "db.json"), false)
+	if err != nil {
+		t.Errorf("db.Open: %v", err)
+		return
+	}
+	defer fdb.Close()
+	pState := state.NewProcessState()
+	pState.DB = fdb
+
+	cooldown := 200 * time.Millisecond
+	feed := Feed{
+		Id:      "pc",
+		Source:  ts.URL + "/feed.xml",
+		Schema:  "podcast",
+		Last:    2,
+		Retry:   &RetryPolicy{MaxAttempts: 1},
+		DumpDir: t.TempDir(),
+		Quarantine: &QuarantinePolicy{
+			After:    1,
+			Cooldown: Duration(cooldown),
+		},
+	}
+	err = feed.Validate()
+	if err != nil {
+		t.Errorf("validate: %v", err)
+		return
+	}
+	process := func() state.FeedResult {
+		go feed.Process(context.Background(), pState)
+		return <-pState.FeedResultChan
+	}
+
+	// 'b' fails, then is quarantined; the feed's validators are
+	// kept once 'b' is quarantined.
+	process()
+	fr := process()
+	if !strings.Contains(fr.FeedResult, "skipped") {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
+	}
+	if fdb.FeedCache("pc").ETag != `"v1"` {
+		t.Errorf("process: expected validators to be kept")
+		return
+	}
+	fr = process()
+	if fr.FeedResult != "Feed unchanged" {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
+	}
+
+	// Feed is got again once the quarantine of 'b' ends.
+	time.Sleep(cooldown)
+	bOK = true
+	requests = 0
+	process()
+	if requests != 1 || !fdb.Exists("pc", "b") {
+		t.Errorf("process: expected 'b' to be downloaded")
+		return
+	}
+	fr = process()
+	if fr.FeedResult != "Feed unchanged" {
+		t.Errorf("process: unexpected result: %v", fr)
+		return
 	}
 }
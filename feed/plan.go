@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ricketyspace.net/fern/db"
+	"ricketyspace.net/fern/schema"
+)
+
+// What fern does with an entry of a feed when the feed is processed.
+type Action string
+
+const (
+	Download Action = "download" // Entry is downloaded
+	Present  Action = "present"  // Entry was downloaded before
+	Skip     Action = "skip"     // Entry is not downloaded
+)
+
+// Reasons for skipping an entry.
+const (
+	SkipTitle       = "title-contains" // Title does not match 'title-contains'
+	SkipQuarantined = "quarantined"    // Entry failed to download repeatedly
+	SkipLast        = "last"           // Entry is not among the 'last' entries
+	SkipBaseline    = "baseline"       // Entry is marked as seen; see Feed.Baseline
+)
+
+// An entry of a feed and what fern does with it.
+type PlannedEntry struct {
+	Entry   schema.Entry
+	Action  Action
+	Reason  string     // Set if Action is Skip
+	Failure db.Failure // Entry's failures; set if Reason is SkipQuarantined
+}
+
+// Decides what to do with each of the feed's entries: the entries
+// whose title does not match the feed's 'title-contains' are skipped;
+// of the rest, the first `feed.Last` entries are downloaded unless
+// they were downloaded before or are quarantined, and the others are
+// skipped. Quarantined entries are downloaded if `retryFailed` is
+// true. If the feed is to be baselined and was not seen before, all
+// entries are skipped; see needsBaseline.
+//
+// Only reads `fdb`.
+func (feed *Feed) plan(fdb *db.FernDB, retryFailed bool,
+	now time.Time) []PlannedEntry {
+	qPolicy := feed.quarantinePolicy()
+	plan := make([]PlannedEntry, 0, len(feed.Entries))
+	traversed := 0
+	baseline := feed.needsBaseline(fdb)
+	for _, e := range feed.Entries {
+		pe := PlannedEntry{Entry: e}
+		switch {
+		case baseline:
+			pe.Action, pe.Reason = Skip, SkipBaseline
+		case traversed >= feed.Last:
+			pe.Action, pe.Reason = Skip, SkipLast
+		case len(feed.TitleContains) > 0 &&
+			!e.TitleContains(feed.TitleContains):
+			pe.Action, pe.Reason = Skip, SkipTitle
+		case fdb.Exists(feed.Id, e.Id):
+			pe.Action = Present
+		case !retryFailed && qPolicy.quarantined(fdb.Failure(feed.Id, e.Id), now):
+			pe.Action, pe.Reason = Skip, SkipQuarantined
+			pe.Failure = fdb.Failure(feed.Id, e.Id)
+		default:
+			pe.Action = Download
+		}
+		if pe.Reason != SkipLast && pe.Reason != SkipTitle {
+			traversed += 1
+		}
+		plan = append(plan, pe)
+	}
+	return plan
+}
+
+// Gets the feed and unmarshals it into feed.Entries, retrying the
+// request as per the feed's retry policy until `shutdown`, if not nil,
+// is closed. See get for `fc`.
+//
+// Returns the validators to use for the next request for the feed. If
+// the feed was got but could not be unmarshaled, the returned error is
+// a *parseError.
+func (feed *Feed) fetch(ctx context.Context, fc db.FeedCache,
+	shutdown <-chan struct{}) (db.FeedCache, error) {
+	var bs []byte
+	_, err := feed.retryPolicy().do(ctx, shutdown, func() error {
+		var err error
+		bs, fc, err = feed.get(ctx, fc)
+		return err
+	}, func(attempt int, wait time.Duration, err error) {
+		fmt.Printf("[%s]: Unable to get feed: %v; retrying in %v\n",
+			feed.Id, err.Error(), wait.Round(time.Millisecond))
+	})
+	if err != nil {
+		return fc, err
+	}
+	err = feed.unmarshal(bs)
+	if err != nil {
+		return fc, &parseError{Err: err}
+	}
+	return fc, nil
+}
+
+// Returned by fetch when the feed cannot be unmarshaled.
+type parseError struct {
+	Err error
+}
+
+func (e *parseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.Err
+}
+
+// Gets the feed and decides what to do with each of its entries,
+// without downloading any of them. The feed is requested
+// unconditionally. See plan for `retryFailed`.
+//
+// Only reads `fdb`.
+func (feed *Feed) Plan(ctx context.Context, fdb *db.FernDB,
+	retryFailed bool) ([]PlannedEntry, error) {
+	_, err := feed.fetch(ctx, db.FeedCache{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return feed.plan(fdb, retryFailed, time.Now()), nil
+}
+
+// Returns true if the feed's entries must be marked as seen, instead
+// of being downloaded: the feed is to be baselined and neither was it
+// baselined before nor does it have entries in `fdb`.
+//
+// Once the feed is baselined, it is not baselined again, even if its
+// first fetch had no entries or all its entries are forgotten.
+func (feed *Feed) needsBaseline(fdb *db.FernDB) bool {
+	return feed.Baseline && !fdb.Baselined(feed.Id) && !fdb.Seen(feed.Id)
+}
+
+// Adds all entries in feed.Entries, that are not already in `fdb`, to
+// `fdb` as seen without downloading them, and records that the feed
+// was baselined.
+//
+// Returns the number of entries added. The error is non-nil if one or
+// more entries, or the baseline, could not be journaled.
+func (feed *Feed) markSeen(fdb *db.FernDB) (int, error) {
+	n := 0
+	jErr := fdb.SetBaselined(feed.Id)
+	for _, e := range feed.Entries {
+		if fdb.Exists(feed.Id, e.Id) {
+			continue
+		}
+		err := fdb.AddRecord(feed.Id, db.Record{
+			EntryId:  e.Id,
+			Title:    e.Title,
+			PubTime:  e.PubTime,
+			Link:     e.Link,
+			Baseline: true,
+		})
+		if err != nil && jErr == nil {
+			jErr = err
+		}
+		n += 1
+	}
+	return n, jErr
+}
+
+// Gets the feed and adds all of its entries, that are not already in
+// `fdb`, to `fdb` as seen without downloading them. The feed is
+// requested unconditionally.
+//
+// Returns the number of entries added.
+func (feed *Feed) MarkSeen(ctx context.Context, fdb *db.FernDB) (int, error) {
+	_, err := feed.fetch(ctx, db.FeedCache{}, nil)
+	if err != nil {
+		return 0, err
+	}
+	return feed.markSeen(fdb)
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"ricketyspace.net/fern/db"
+	"ricketyspace.net/fern/schema"
+)
+
+func TestPlan(t *testing.T) {
+	fdb, err := db.Open(path.Join(t.TempDir(), "db.json"), false)
+	if err != nil {
+		t.Errorf("db.Open: %v", err)
+		return
+	}
+	defer fdb.Close()
+	fdb.Add("npr", "2")
+	fdb.AddFailure("npr", "4", fmt.Errorf("failed"))
+
+	feed := Feed{
+		Id:            "npr",
+		Last:          3,
+		TitleContains: "desk",
+		Quarantine:    &QuarantinePolicy{After: 1, Cooldown: Duration(time.Hour)},
+	}
+	for i, title := range []string{"desk", "desk", "concert", "desk", "desk", "desk"} {
+		feed.Entries = append(feed.Entries, schema.Entry{
+			Id:    fmt.Sprint(i + 1),
+			Title: title,
+		})
+	}
+	now := time.Now()
+	tests := []struct {
+		retryFailed bool
+		plan        string
+	}{
+		{false, "1:download 2:present 3:skip:title-contains" +
+			" 4:skip:quarantined 5:skip:last 6:skip:last "},
+		{true, "1:download 2:present 3:skip:title-contains" +
+			" 4:download 5:skip:last 6:skip:last "},
+	}
+	for _, test := range tests {
+		s := ""
+		for _, pe := range feed.plan(fdb, test.retryFailed, now) {
+			s += pe.Entry.Id + ":" + string(pe.Action)
+			if len(pe.Reason) > 0 {
+				s += ":" + pe.Reason
+			}
+			s += " "
+		}
+		if s != test.plan {
+			t.Errorf("plan: '%s' != '%s'", s, test.plan)
+			return
+		}
+	}
+
+	// Baselined feed that was not seen before.
+	feed.Id = "new"
+	feed.Baseline = true
+	for _, pe := range feed.plan(fdb, false, now) {
+		if pe.Action != Skip || pe.Reason != SkipBaseline {
+			t.Errorf("plan: expected baseline skip: %v", pe)
+			return
+		}
+	}
+}
+
+func TestPlanDryRun(t *testing.T) {
+	downloads := 0
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Header().Set("ETag", "\"v1\"")
+		w.Write([]byte(testPodcast(ts.URL, "a", "b")))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		downloads += 1
+		w.Write([]byte("media"))
+	})
+
+	dbDir := t.TempDir()
+	fdb, err := db.Open(path.Join(dbDir, "db.json"), false)
+	if err != nil {
+		t.Errorf("db.Open: %v", err)
+		return
+	}
+	defer fdb.Close()
+	before := dirContents(t, dbDir)
+
+	feed := Feed{
+		Id:      "dry",
+		Source:  ts.URL + "/feed.xml",
+		Schema:  "podcast",
+		Last:    2,
+		DumpDir: t.TempDir(),
+	}
+	err = feed.Validate()
+	if err != nil {
+		t.Errorf("validate: %v", err)
+		return
+	}
+	plan, err := feed.Plan(context.Background(), fdb, false)
+	if err != nil {
+		t.Errorf("plan: %v", err)
+		return
+	}
+	if len(plan) != 2 || plan[0].Action != Download ||
+		plan[1].Action != Download {
+		t.Errorf("plan: unexpected plan: %v", plan)
+		return
+	}
+	if downloads != 0 {
+		t.Errorf("plan: %d entries downloaded", downloads)
+		return
+	}
+	if fdb.Exists("dry", "a") || fdb.Exists("dry", "b") ||
+		fdb.FeedCache("dry") != (db.FeedCache{}) {
+		t.Errorf("plan: db changed")
+		return
+	}
+	after := dirContents(t, dbDir)
+	if len(after) != len(before) {
+		t.Errorf("plan: db files changed: %v != %v", after, before)
+		return
+	}
+	for name, content := range before {
+		if after[name] != content {
+			t.Errorf("plan: db file changed: %s", name)
+			return
+		}
+	}
+}
+
+// Returns the contents of the files in `dir` by name.
+func dirContents(t *testing.T, dir string) map[string]string {
+	contents := make(map[string]string)
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	for _, de := range des {
+		bs, err := os.ReadFile(path.Join(dir, de.Name()))
+		if err != nil {
+			t.Fatalf("read file: %v", err)
+		}
+		contents[de.Name()] = string(bs)
+	}
+	return contents
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"ricketyspace.net/fern/db"
+)
+
+// Policy for skipping entries that failed to download in several
+// runs of fern in a row.
+//
+// Fields that are not set are inherited from the policy being
+// overridden; see Override. A field is set if it is in the JSON the
+// policy is unmarshaled from, or if it is not zero.
+type QuarantinePolicy struct {
+	// Number of runs an entry must fail to download in before it
+	// is skipped. A number less than 1 disables quarantine.
+	After int `json:"after"`
+	// How long a quarantined entry is skipped for after its last
+	// failed attempt.
+	Cooldown Duration `json:"cooldown"`
+	// Keys in the JSON the policy was unmarshaled from.
+	keys map[string]bool
+}
+
+func (p *QuarantinePolicy) UnmarshalJSON(bs []byte) error {
+	type policy QuarantinePolicy // Without the UnmarshalJSON method
+	err := json.Unmarshal(bs, (*policy)(p))
+	if err != nil {
+		return err
+	}
+	p.keys, err = jsonKeys(bs)
+	return err
+}
+
+// Returns true if the field with the JSON key `key`, which is zero if
+// `zero` is true, is set in the policy; see QuarantinePolicy.
+func (p *QuarantinePolicy) set(key string, zero bool) bool {
+	return p.keys[key] || !zero
+}
+
+// Quarantine policy used when none is set in the config.
+var DefaultQuarantinePolicy = QuarantinePolicy{
+	After:    3,
+	Cooldown: Duration(24 * time.Hour),
+}
+
+// Returns a copy of the policy with the fields that are set in `o`
+// overridden. If `o` is nil, returns the policy as is.
+func (p QuarantinePolicy) Override(o *QuarantinePolicy) QuarantinePolicy {
+	if o == nil {
+		return p
+	}
+	if o.set("after", o.After == 0) {
+		p.After = o.After
+	}
+	if o.set("cooldown", o.Cooldown == 0) {
+		p.Cooldown = o.Cooldown
+	}
+	p.keys = nil
+	return p
+}
+
+// Validates the policy.
+//
+// Returns nil if validation succeeds; error otherwise.
+func (p QuarantinePolicy) Validate() error {
+	if p.Cooldown < 0 {
+		return fmt.Errorf("'cooldown' must not be negative")
+	}
+	return nil
+}
+
+// Returns true if an entry that has failed `f` is quarantined at
+// time `now`.
+func (p QuarantinePolicy) quarantined(f db.Failure, now time.Time) bool {
+	if p.After < 1 || f.Count < p.After {
+		return false
+	}
+	return now.Before(p.until(f))
+}
+
+// Returns the time until which an entry that has failed `f` is
+// quarantined.
+func (p QuarantinePolicy) until(f db.Failure) time.Time {
+	return f.LastAttempt.Add(time.Duration(p.Cooldown))
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"ricketyspace.net/fern/db"
+)
+
+func TestQuarantinePolicyJSON(t *testing.T) {
+	bs := []byte(`{"cooldown": "1h"}`)
+	p := new(QuarantinePolicy)
+	err := json.Unmarshal(bs, p)
+	if err != nil {
+		t.Errorf("unmarshal: %v", err)
+		return
+	}
+	qp := DefaultQuarantinePolicy.Override(p)
+	if qp.Cooldown != Duration(time.Hour) ||
+		qp.After != DefaultQuarantinePolicy.After {
+		t.Errorf("override: %v", qp)
+		return
+	}
+
+	// Explicit zeros override.
+	p = new(QuarantinePolicy)
+	err = json.Unmarshal([]byte(`{"after": 0, "cooldown": "0s"}`), p)
+	if err != nil {
+		t.Errorf("unmarshal: %v", err)
+		return
+	}
+	qp = DefaultQuarantinePolicy.Override(p)
+	if qp.After != 0 || qp.Cooldown != 0 {
+		t.Errorf("override with zeros: %v", qp)
+		return
+	}
+
+	if err = (QuarantinePolicy{Cooldown: -1}).Validate(); err == nil {
+		t.Errorf("validate: negative cooldown is valid")
+		return
+	}
+}
+
+func TestQuarantined(t *testing.T) {
+	now := time.Now()
+	qp := QuarantinePolicy{After: 3, Cooldown: Duration(time.Hour)}
+	tests := []struct {
+		f           db.Failure
+		quarantined bool
+	}{
+		{db.Failure{}, false},
+		{db.Failure{Count: 2, LastAttempt: now}, false},
+		{db.Failure{Count: 3, LastAttempt: now}, true},
+		{db.Failure{Count: 5, LastAttempt: now.Add(-59 * time.Minute)}, true},
+		{db.Failure{Count: 5, LastAttempt: now.Add(-61 * time.Minute)}, false},
+	}
+	for _, test := range tests {
+		if qp.quarantined(test.f, now) != test.quarantined {
+			t.Errorf("quarantined(%v) != %v", test.f, test.quarantined)
+			return
+		}
+	}
+
+	// Disabled.
+	qp.After = -1
+	if qp.quarantined(db.Failure{Count: 5, LastAttempt: now}, now) {
+		t.Errorf("quarantined: disabled policy quarantines")
+		return
+	}
+}
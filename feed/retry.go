@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A time.Duration that is represented in JSON as a string like "2s"
+// or "1m30s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+	var s string
+	err := json.Unmarshal(bs, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Policy for retrying feed requests and downloads that fail.
+//
+// Fields that are not set are inherited from the policy being
+// overridden; see Override. A field is set if it is in the JSON the
+// policy is unmarshaled from, or if it is not zero.
+type RetryPolicy struct {
+	// Number of attempts, including the first one. 1 disables
+	// retrying.
+	MaxAttempts int `json:"max-attempts"`
+	// Wait before the second attempt; it is doubled after each
+	// attempt up to MaxBackoff.
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max-backoff"`
+	// Fraction, between 0 and 1, by which each wait is randomly
+	// lengthened or shortened.
+	Jitter float64 `json:"jitter"`
+	// HTTP status codes to retry.
+	StatusCodes []int `json:"status-codes"`
+	// Exit codes of yt-dlp, youtube-dl or the download command to
+	// retry. If empty, all non-zero exit codes are retried.
+	ExitCodes []int `json:"exit-codes"`
+	// Keys in the JSON the policy was unmarshaled from.
+	keys map[string]bool
+}
+
+func (p *RetryPolicy) UnmarshalJSON(bs []byte) error {
+	type policy RetryPolicy // Without the UnmarshalJSON method
+	err := json.Unmarshal(bs, (*policy)(p))
+	if err != nil {
+		return err
+	}
+	p.keys, err = jsonKeys(bs)
+	return err
+}
+
+// Returns the keys, in lower case, of the JSON object `bs`.
+func jsonKeys(bs []byte) (map[string]bool, error) {
+	raw := make(map[string]json.RawMessage)
+	err := json.Unmarshal(bs, &raw)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(raw))
+	for k := range raw {
+		keys[strings.ToLower(k)] = true
+	}
+	return keys, nil
+}
+
+// Returns true if the field with the JSON key `key`, which is zero if
+// `zero` is true, is set in the policy; see RetryPolicy.
+func (p *RetryPolicy) set(key string, zero bool) bool {
+	return p.keys[key] || !zero
+}
+
+// Retry policy used when none is set in the config.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     Duration(2 * time.Second),
+	MaxBackoff:  Duration(time.Minute),
+	Jitter:      0.2,
+	StatusCodes: []int{408, 429, 500, 502, 503, 504},
+}
+
+// Returned by a downloader when the media it got is shorter than
+// expected.
+var errIncomplete = errors.New("incomplete download")
+
+// Returns a copy of the policy with the fields that are set in `o`
+// overridden. If `o` is nil, returns the policy as is.
+func (p RetryPolicy) Override(o *RetryPolicy) RetryPolicy {
+	if o == nil {
+		return p
+	}
+	if o.set("max-attempts", o.MaxAttempts == 0) {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.set("backoff", o.Backoff == 0) {
+		p.Backoff = o.Backoff
+	}
+	if o.set("max-backoff", o.MaxBackoff == 0) {
+		p.MaxBackoff = o.MaxBackoff
+	}
+	if o.set("jitter", o.Jitter == 0) {
+		p.Jitter = o.Jitter
+	}
+	if o.set("status-codes", o.StatusCodes == nil) {
+		p.StatusCodes = o.StatusCodes
+	}
+	if o.set("exit-codes", o.ExitCodes == nil) {
+		p.ExitCodes = o.ExitCodes
+	}
+	p.keys = nil
+	return p
+}
+
+// Validates the policy.
+//
+// Returns nil if validation succeeds; error otherwise.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("'max-attempts' must be at least 1")
+	}
+	if p.Backoff < 0 || p.MaxBackoff < 0 {
+		return fmt.Errorf("'backoff' and 'max-backoff' must not" +
+			" be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("'jitter' must be between 0 and 1")
+	}
+	return nil
+}
+
+// Returns true if the failure `err` is worth retrying: a response
+// with one of the policy's status codes, an exit code of the
+// downloader that is to be retried, a timeout, a temporary DNS
+// failure, or a connection that could not be made or was cut short.
+// Failures that will not go away on their own, like a name that does
+// not resolve, a certificate that does not verify or an unsupported
+// URL, are not retried.
+func (p RetryPolicy) retryable(err error) bool {
+	var he *HTTPError
+	var ee *exec.ExitError
+	var de *net.DNSError
+	var ce *tls.CertificateVerificationError
+	var ne net.Error
+	var oe *net.OpError
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &he):
+		return slices.Contains(p.StatusCodes, he.StatusCode)
+	case errors.As(err, &ee):
+		if ee.ExitCode() < 0 {
+			return false // Killed.
+		}
+		return len(p.ExitCodes) == 0 ||
+			slices.Contains(p.ExitCodes, ee.ExitCode())
+	case errors.As(err, &de):
+		return de.IsTimeout || de.IsTemporary
+	case errors.As(err, &ce):
+		return false
+	case errors.As(err, &ne) && ne.Timeout():
+		return true
+	case errors.As(err, &oe),
+		errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, errIncomplete):
+		return true
+	}
+	return false
+}
+
+// Returns how long to wait after the failed attempt number `attempt`.
+func (p RetryPolicy) wait(attempt int) time.Duration {
+	w := time.Duration(p.Backoff)
+	for i := 1; i < attempt && w < time.Duration(p.MaxBackoff); i++ {
+		w *= 2
+	}
+	if p.MaxBackoff > 0 && w > time.Duration(p.MaxBackoff) {
+		w = time.Duration(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		w += time.Duration(p.Jitter * (2*rand.Float64() - 1) *
+			float64(w))
+	}
+	return w
+}
+
+// Calls `fn` until it succeeds, fails with an error that is not
+// retryable, the policy's attempts run out, `ctx` is done or
+// `shutdown` is closed; an attempt in progress when fern starts to
+// shut down is not retried. `retrying`, if not nil, is called before
+// waiting to retry.
+//
+// Returns the number of attempts made and the error of the last
+// attempt.
+func (p RetryPolicy) do(ctx context.Context, shutdown <-chan struct{},
+	fn func() error,
+	retrying func(attempt int, wait time.Duration, err error)) (int, error) {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts ||
+			!p.retryable(err) || ctx.Err() != nil ||
+			closed(shutdown) {
+			return attempt, err
+		}
+
+		w := p.wait(attempt)
+		if retrying != nil {
+			retrying(attempt, w, err)
+		}
+		t := time.NewTimer(w)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return attempt, err
+		case <-shutdown:
+			t.Stop()
+			return attempt, err
+		}
+	}
+}
+
+// Returns true if `c` is closed; false if it is not or is nil.
+func closed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
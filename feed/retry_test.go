@@ -0,0 +1,220 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package feed
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"ricketyspace.net/fern/schema"
+	"ricketyspace.net/fern/state"
+)
+
+func TestRetryPolicyJSON(t *testing.T) {
+	bs := []byte(`{"max-attempts": 5, "backoff": "1m30s", "exit-codes": [1]}`)
+	p := new(RetryPolicy)
+	err := json.Unmarshal(bs, p)
+	if err != nil {
+		t.Errorf("unmarshal: %v", err)
+		return
+	}
+	rp := DefaultRetryPolicy.Override(p)
+	if rp.MaxAttempts != 5 || rp.Backoff != Duration(90*time.Second) ||
+		len(rp.ExitCodes) != 1 {
+		t.Errorf("override: %v", rp)
+		return
+	}
+	// Not overridden.
+	if rp.MaxBackoff != DefaultRetryPolicy.MaxBackoff ||
+		rp.Jitter != DefaultRetryPolicy.Jitter ||
+		len(rp.StatusCodes) != len(DefaultRetryPolicy.StatusCodes) {
+		t.Errorf("override: %v", rp)
+		return
+	}
+
+	// Explicit zeros override.
+	p = new(RetryPolicy)
+	err = json.Unmarshal([]byte(`{"jitter": 0, "backoff": "0s",`+
+		` "status-codes": []}`), p)
+	if err != nil {
+		t.Errorf("unmarshal: %v", err)
+		return
+	}
+	rp = DefaultRetryPolicy.Override(p)
+	if rp.Jitter != 0 || rp.Backoff != 0 || len(rp.StatusCodes) != 0 {
+		t.Errorf("override with zeros: %v", rp)
+		return
+	}
+	if rp.MaxAttempts != DefaultRetryPolicy.MaxAttempts ||
+		rp.MaxBackoff != DefaultRetryPolicy.MaxBackoff {
+		t.Errorf("override with zeros: %v", rp)
+		return
+	}
+
+	err = json.Unmarshal([]byte(`{"backoff": 2}`), p)
+	if err == nil {
+		t.Errorf("unmarshal: expected error for numeric backoff")
+		return
+	}
+	err = DefaultRetryPolicy.Override(&RetryPolicy{Jitter: 2}).Validate()
+	if err == nil {
+		t.Errorf("validate: expected error for jitter 2")
+		return
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	p := DefaultRetryPolicy
+	exitErr := exec.Command("sh", "-c", "exit 2").Run()
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&HTTPError{StatusCode: 503}, true},
+		{&HTTPError{StatusCode: 404}, false},
+		{fmt.Errorf("GET: %w", errIncomplete), true},
+		{exitErr, true},
+		{context.Canceled, false},
+		{fmt.Errorf("URL invalid"), false},
+		{&url.Error{Op: "Get", URL: "ftp://x/a",
+			Err: errors.New("unsupported protocol scheme \"ftp\"")}, false},
+		{&url.Error{Op: "Get", URL: "https://x/a",
+			Err: &net.OpError{Op: "dial", Net: "tcp",
+				Err: &net.DNSError{Name: "x", IsNotFound: true}}}, false},
+		{&url.Error{Op: "Get", URL: "https://x/a",
+			Err: &net.DNSError{Name: "x", IsTemporary: true}}, true},
+		{&url.Error{Op: "Get", URL: "https://x/a",
+			Err: &tls.CertificateVerificationError{
+				Err: x509.UnknownAuthorityError{}}}, false},
+		{&url.Error{Op: "Get", URL: "https://x/a",
+			Err: &net.OpError{Op: "dial", Net: "tcp",
+				Err: syscall.ECONNREFUSED}}, true},
+		{&url.Error{Op: "Get", URL: "https://x/a",
+			Err: os.ErrDeadlineExceeded}, true},
+		{&url.Error{Op: "Get", URL: "https://x/a", Err: io.EOF}, true},
+	}
+	for _, test := range tests {
+		if p.retryable(test.err) != test.retryable {
+			t.Errorf("retryable: %v: expected %v", test.err,
+				test.retryable)
+			return
+		}
+	}
+
+	p.ExitCodes = []int{1}
+	if p.retryable(exitErr) {
+		t.Errorf("retryable: exit code 2 not in %v", p.ExitCodes)
+		return
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	p := RetryPolicy{
+		Backoff:    Duration(time.Second),
+		MaxBackoff: Duration(5 * time.Second),
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second,
+		4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range expected {
+		if p.wait(i+1) != w {
+			t.Errorf("wait %d: %v != %v", i+1, p.wait(i+1), w)
+			return
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		w := p.wait(2)
+		if w < time.Second || w > 3*time.Second {
+			t.Errorf("wait with jitter: %v", w)
+			return
+		}
+	}
+}
+
+func TestProcessEntryRetry(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests += 1
+			if requests < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("42"))
+		}))
+	defer ts.Close()
+
+	feed := new(Feed)
+	feed.Id = "npr"
+	feed.Schema = "npr"
+	feed.DumpDir = t.TempDir()
+	feed.Retry = &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     Duration(time.Millisecond),
+	}
+	entry := schema.Entry{
+		Id:    "42",
+		Title: "The Answer",
+		Link:  ts.URL + "/42.mp3",
+	}
+	pState := state.NewProcessState()
+	erc := make(chan state.EntryResult, 1)
+	feed.processEntry(context.Background(), entry, erc, pState.Limiter,
+		pState.Shutdown)
+	er := <-erc
+	if er.Err != nil {
+		t.Errorf("processEntry: %v", er.Err)
+		return
+	}
+	if er.Attempts != 3 {
+		t.Errorf("processEntry: attempts: %d", er.Attempts)
+		return
+	}
+
+	// Attempts run out.
+	requests = 0
+	feed.Retry.MaxAttempts = 2
+	feed.processEntry(context.Background(), entry, erc, pState.Limiter,
+		pState.Shutdown)
+	er = <-erc
+	if er.Err == nil || er.Attempts != 2 {
+		t.Errorf("processEntry: expected failure after 2 attempts:"+
+			" %d: %v", er.Attempts, er.Err)
+		return
+	}
+
+	// Shutdown starts during the first attempt; it is not retried.
+	shutdown := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			pState.StartShutdown()
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	defer shutdown.Close()
+	feed.Retry.MaxAttempts = 3
+	feed.Retry.Backoff = Duration(time.Hour)
+	entry.Link = shutdown.URL + "/42.mp3"
+	feed.processEntry(context.Background(), entry, erc, pState.Limiter,
+		pState.Shutdown)
+	er = <-erc
+	if er.Err == nil || er.Attempts != 1 {
+		t.Errorf("processEntry: expected failure after 1 attempt"+
+			" on shutdown: %d: %v", er.Attempts, er.Err)
+		return
+	}
+}
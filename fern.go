@@ -3,176 +3,531 @@
 
 // fern is a simple media feed downloader.
 //
-// It depends on yt-dlp to download the media found in the media feeds
-// to your computer.
+// It depends on yt-dlp to download the media found in YouTube feeds
+// to your computer. Media in NPR and Podcast feeds is downloaded
+// directly by fern.
 //
-// fern currently supports YoutTube, NPR, and Podcast feeds.
+// fern currently supports YoutTube, NPR, and Podcast feeds, and RSS
+// 2.0, Atom and JSON Feed feeds in general.
 //
 // Information about what media feeds to download, the location of
 // yt-dlp program on your computer, and the directory where the media
 // should be downloaded to must be specified in a config file which
-// fern expects to be at $HOME/.config/fern/fern.json
+// fern expects to be at $XDG_CONFIG_HOME/fern/fern.json, or at
+// $HOME/.config/fern/fern.json if XDG_CONFIG_HOME is not set. To use
+// a config file elsewhere, set FERN_CONFIG to its path or do:
 //
-// fern's config file contains three fields:
+//	$ fern -config /path/to/fern.json run
+//
+// fern keeps track of what it downloaded in a database which, unless
+// "db" is set in the config, is at $XDG_STATE_HOME/fern/db.json, or at
+// $HOME/.local/state/fern/db.json if XDG_STATE_HOME is not set. A
+// database at $HOME/.config/fern/db.json, where older versions of fern
+// kept it, continues to be used. The database's path may also be set
+// with FERN_DB or with the -db flag.
+//
+// fern's config file contains these fields:
 //
 //	{
-//	   "ydl-path": "/usr/local/bin/yt-dlp",
-//	   "dump-dir": "~/media/feeds", // media feed download directory
+//	   "ydl-path": "/usr/local/bin/yt-dlp", // needed only for youtube feeds
+//	   "ydl-args": ["-f", "bestaudio"], // optional. extra arguments to yt-dlp or youtube-dl
+//	   "output-template": "%(title)s-%(id)s.%(ext)s", // optional. yt-dlp or youtube-dl output template, relative to the feed's download directory
+//	   "dump-dir": "~/media/feeds", // media feed download directory; optional if every feed sets its own
+//	   "dir-template": "{feed}/{year}/{month}", // optional. layout of a feed's media in the download directory; defaults to "{feed}"
+//	   "db": "~/media/feeds/fern-db.json", // optional. path to the database; relative to the config's directory
+//	   "retry": {...}, // optional. policy for retrying failed requests and downloads
+//	   "quarantine": {...}, // optional. policy for skipping entries that fail repeatedly
+//	   "max-downloads": 10, // optional. downloads at once; -1 for no limit
+//	   "max-downloads-per-host": 2, // optional. downloads at once from a host; no limit by default
+//	   "host-limits": {"youtube.com": 1}, // optional. downloads at once from a host and its subdomains; overrides "max-downloads-per-host"
+//	   "max-feeds": 10, // optional. feeds fetched at once; -1 for no limit
 //	   "feeds": [...] // list of media feeds.
 //	}
 //
+// fern's "retry" policy, which may be overridden field by field in a
+// feed, defaults to:
+//
+//	{
+//	   "max-attempts": 3, // attempts, including the first one
+//	   "backoff": "2s", // wait before retrying; doubled after each attempt
+//	   "max-backoff": "1m", // longest wait before retrying
+//	   "jitter": 0.2, // fraction by which a wait is randomly lengthened or shortened
+//	   "status-codes": [408, 429, 500, 502, 503, 504], // HTTP status codes to retry
+//	   "exit-codes": [] // yt-dlp, youtube-dl or command exit codes to retry; all if empty
+//	}
+//
+// Besides those status and exit codes, only timeouts and connections
+// that fail or are cut short are retried; names that do not resolve
+// and certificates that do not verify are not.
+//
+// fern records the entries that fail to download in its database. An
+// entry that failed in several runs of fern is quarantined: it is
+// skipped until a cooldown passes after its last failure. fern's
+// "quarantine" policy, which may be overridden field by field in a
+// feed, defaults to:
+//
+//	{
+//	   "after": 3, // failed runs after which an entry is quarantined; -1 disables quarantine
+//	   "cooldown": "24h" // how long a quarantined entry is skipped for
+//	}
+//
 // Each item in the media "feeds" must be:
 //
 //	{
 //	   "id": "media-feed-id", // unique identifier for the media feed
 //	   "source": "https://feeds.npr.org/XXXX/rss.xml", // media feed url
-//	   "schema": "npr", // must be "youtube" or "npr" or "podcast"
+//	   "schema": "npr", // must be "youtube", "npr", "podcast", "rss", "atom", "jsonfeed" or "auto"
 //	   "last": 5 // the last N items that should be downloaded
+//	   "tags": ["music", "npr"] // optional. for selecting the feed in commands like 'fern run -tag music'
+//	   "baseline": true // optional. when the feed is first seen, mark its entries as seen instead of downloading them
 //	   "title-contains": "tiny desk" // optional. if specified, downloads entries with title matching the value of this field
+//	   "downloader": "native" // optional. must be "yt-dlp", "youtube-dl", "native" or "command"
+//	   "command": ["curl", "-sL", "-o", "{dir}/{id}.mp3", "{url}"] // command template for the "command" downloader
+//	   "ydl-path": "/usr/bin/youtube-dl" // optional. overrides the config's "ydl-path"
+//	   "ydl-args": ["--embed-thumbnail"] // optional. overrides the config's "ydl-args"
+//	   "output-template": "%(id)s.%(ext)s" // optional. overrides the config's "output-template"
+//	   "dump-dir": "/mnt/nas/videos" // optional. overrides the config's "dump-dir"
+//	   "dir-template": "{feed}/{year}" // optional. overrides the config's "dir-template"
+//	   "retry": {"max-attempts": 5} // optional. overrides fields in the config's "retry" policy
+//	   "quarantine": {"after": -1} // optional. overrides fields in the config's "quarantine" policy
 //	}
 //
+// The "rss" and "atom" schemas download the enclosure of each entry,
+// or its link if it has none; the "jsonfeed" schema downloads the
+// first attachment of each item, or its URL if it has none. With
+// "auto", the schema is detected from the feed each time it is
+// fetched.
+//
+// When "downloader" is not set, YouTube feeds, including "auto" feeds
+// detected to be YouTube feeds, are downloaded via yt-dlp and all
+// other feeds are downloaded natively by fern; an "auto" feed detected
+// to be a YouTube feed fails if "ydl-path" is not set. The
+// placeholders {url}, {id}, {title} and {dir} in "command" are
+// replaced by the entry's link, id, title and download directory.
+//
+// The placeholders {feed}, {year}, {month} and {day} in "dir-template"
+// are replaced by the feed's id and the date the entry was published.
+// Entries without a date are put in an "undated" directory in place of
+// the date.
+//
 // You may download an example config file for fern from
 // https://ricketyspace.net/fern/fern.json
 //
-// Run fern with:
+// fern is used through commands:
 //
-//	$ fern -run
+//	$ fern run                  # download new entries in the feeds
+//	$ fern list                 # list the feeds in the config
+//	$ fern status               # show what was downloaded for each feed
+//	$ fern mark-seen feed       # mark the feed's entries as seen without downloading them
+//	$ fern config check         # validate the config
+//	$ fern db list [feed]       # list the entries in the database
+//	$ fern db forget feed entry # remove an entry from the database, to download it again
+//	$ fern db mark feed entry   # mark an entry as downloaded
+//	$ fern db prune             # remove the feeds that are not in the config from the database
+//	$ fern version              # print fern's version
 //
-// To print fern's version, do:
+// Do `fern help <command>` for more about a command.
 //
-//	$ fern -version
+// To download new entries in only some of the feeds, name them by id
+// or by a glob pattern, or select them by tag:
+//
+//	$ fern run npr-tiny-desk 'npr-*' -tag news
+//
+// To see which entries fern would download, without downloading them,
+// do:
+//
+//	$ fern run -dry-run
+//
+// Only one fern may run at a time. To have fern wait for another
+// running fern to finish, instead of exiting with an error, do:
+//
+//	$ fern run -wait
+//
+// To run fern and try quarantined entries again right away, do:
+//
+//	$ fern run -retry-failed
+//
+// To stop fern, press Ctrl-C or send it SIGTERM. fern does not start
+// new downloads after that and exits once the downloads in progress
+// finish. Press Ctrl-C again to stop the downloads in progress and
+// exit right away.
+//
+// The -run, -retry-failed and -version flags of older versions of fern
+// still work, but are deprecated.
 package main
 
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path"
-	"runtime"
-	"runtime/pprof"
-	"time"
+	"strings"
+	"text/tabwriter"
 
 	"ricketyspace.net/fern/config"
 	"ricketyspace.net/fern/db"
-	"ricketyspace.net/fern/state"
 	"ricketyspace.net/fern/version"
 )
 
-var fConf *config.FernConfig
-var pState *state.ProcessState
+// A fern command, like `fern run` or `fern config check`.
+type command struct {
+	name  string // Name of the command
+	args  string // Synopsis of the command's flags and arguments
+	short string // One line description of the command
+	long  string // Optional. Description shown in the command's help
+	// Runs the command with the arguments that follow its name;
+	// returns the exit code. Not set for a group of commands.
+	run func(cmd *command, args []string) int
+	// Commands in the group, like `check` in `fern config`.
+	commands []*command
+	// Group the command is in; nil for fern itself.
+	parent *command
+}
 
-var vFlag *bool
-var rFlag *bool
-var pFlag *string
-var profileSuffix string
+var fern = &command{
+	name:  "fern",
+	args:  "[-config FILE] [-db FILE] <command> [arguments]",
+	short: "fern is a simple media feed downloader.",
+}
 
 func init() {
-	var err error
+	// Setup logger.
+	log.SetFlags(0)
 
-	// Get fern config.
-	fConf, err = config.Read()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err.Error())
-		os.Exit(1)
+	fern.commands = []*command{
+		cmdRun,
+		cmdList,
+		cmdStatus,
+		cmdMarkSeen,
+		cmdConfig,
+		cmdDB,
+		cmdVersion,
+		cmdHelp,
 	}
+	fern.setParents()
+}
 
-	// Initialize process state.
-	pState = state.NewProcessState()
+// Sets the parent of the commands in the group, recursively.
+func (cmd *command) setParents() {
+	for _, c := range cmd.commands {
+		c.parent = cmd
+		c.setParents()
+	}
+}
 
-	// Open database.
-	pState.DB, err = db.Open()
-	if err != nil {
-		fmt.Printf("Error: %v\n", err.Error())
-		os.Exit(1)
+// Returns the command's full name, like "fern config check".
+func (cmd *command) path() string {
+	if cmd.parent == nil {
+		return cmd.name
 	}
+	return cmd.parent.path() + " " + cmd.name
+}
 
-	// Parse args.
-	vFlag = flag.Bool("version", false, "Print version")
-	rFlag = flag.Bool("run", false, "Run fern")
-	pFlag = flag.String("prof", "",
-		"Write cpu and memory profiles to the specified directory")
-	flag.Parse()
+// Returns the command named `name` in the group; nil if there is none.
+func (cmd *command) lookup(name string) *command {
+	for _, c := range cmd.commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
 
-	if *vFlag {
-		fmt.Printf("%s\n", version.Version)
-		os.Exit(0)
+// Writes the command's usage to `w`.
+func (cmd *command) usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s %s\n", cmd.path(), cmd.args)
+	if len(cmd.long) > 0 {
+		fmt.Fprintf(w, "\n%s\n", cmd.long)
+	} else {
+		fmt.Fprintf(w, "\n%s\n", cmd.short)
 	}
-	if !*rFlag {
-		printUsage(2)
+	if len(cmd.commands) > 0 {
+		fmt.Fprintf(w, "\nCommands:\n")
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, c := range cmd.commands {
+			fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.short)
+		}
+		tw.Flush()
+		fmt.Fprintf(w, "\nDo 'fern help%s <command>' for more about"+
+			" a command.\n", strings.TrimPrefix(cmd.path(), fern.name))
 	}
-	if *pFlag != "" {
-		profileSuffix = fmt.Sprintf("%d.prof", time.Now().UnixMilli())
+}
+
+// Returns a FlagSet for the command's flags that prints the command's
+// usage on -h and on errors.
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.path(), flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		cmd.usage(fs.Output())
+		n := 0
+		fs.VisitAll(func(*flag.Flag) { n++ })
+		if n > 0 {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
 	}
+	return fs
+}
 
-	// Setup logger.
-	log.SetFlags(0)
+// Paths to the config and the db given by the -config and -db flags.
+var configFlag, dbFlag string
+
+// Adds the -config and -db flags to `fs`. They may be given before
+// the command, as in `fern -config team.json run`, or after it.
+func locationFlags(fs *flag.FlagSet) {
+	fs.StringVar(&configFlag, "config", configFlag,
+		"Read the config from `FILE`")
+	fs.StringVar(&dbFlag, "db", dbFlag, "Use the database at `FILE`")
 }
 
-func printUsage(exit int) {
-	fmt.Printf("fern [ -run [ -prof DIR ] | -version ]\n")
-	flag.PrintDefaults()
-	os.Exit(exit)
+// Reads and validates the fern config; see config.Path for where it
+// is read from.
+func readConfig() (*config.FernConfig, error) {
+	p, err := config.Path(configFlag)
+	if err != nil {
+		return nil, err
+	}
+	return config.Read(p)
 }
 
-func main() {
-	// Setup CPU and memory profiling if enabled.
-	if *pFlag != "" {
-		// CPU profiling.
-		cn := path.Join(*pFlag, "cpu."+profileSuffix)
-		cf, err := os.Create(cn)
+// Reads and validates the fern config without preparing it for use;
+// see config.Inspect. For commands that do not download.
+func inspectConfig() (*config.FernConfig, error) {
+	p, err := config.Path(configFlag)
+	if err != nil {
+		return nil, err
+	}
+	return config.Inspect(p)
+}
+
+// Opens the fern db; see config.DBPath for where it is. If `fConf` is
+// nil, the config is read, without validating it, to find the db; a
+// missing config is ignored.
+func openDB(fConf *config.FernConfig, wait bool) (*db.FernDB, error) {
+	if fConf == nil && len(dbFlag) == 0 && len(os.Getenv("FERN_DB")) == 0 {
+		p, err := config.Path(configFlag)
 		if err != nil {
-			log.Fatal("could not create CPU profile: ", err)
+			return nil, err
 		}
-		defer cf.Close()
-		if err := pprof.StartCPUProfile(cf); err != nil {
-			log.Fatal("could not start CPU profile: ", err)
+		fConf, err = config.Load(p)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
 		}
-		defer pprof.StopCPUProfile()
+	}
+	p, err := config.DBPath(dbFlag, fConf)
+	if err != nil {
+		return nil, err
+	}
+	return db.Open(p, wait)
+}
+
+// A flag that may be given more than once, like `-tag news -tag music`.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
 
-		// Memory profiling.
-		mn := path.Join(*pFlag, "mem."+profileSuffix)
-		mf, err := os.Create(mn)
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// Parses `args` with `fs`; flags may come before, after or in between
+// the other arguments, until "--". If the other arguments are fewer
+// than `min` or more than `max`, prints the command's usage and
+// fails; a negative `max` is no limit.
+//
+// Returns the arguments that are not flags and true if parsing
+// succeeds. Otherwise, returns false and the code fern must exit with.
+func (cmd *command) parse(fs *flag.FlagSet, args []string,
+	min, max int) ([]string, bool, int) {
+	pos := []string{}
+	for {
+		err := fs.Parse(args)
+		if err == flag.ErrHelp {
+			return nil, false, 0
+		}
 		if err != nil {
-			log.Fatal("could not create memory profile: ", err)
+			return nil, false, 2
 		}
-		defer mf.Close()
-		defer func() {
-			runtime.GC()
-			if err := pprof.WriteHeapProfile(mf); err != nil {
-				log.Fatal("could not write memory profile: ", err)
-			}
-		}()
-		log.Printf("Profiling enabled. CPU and memory profiles"+
-			" will be written to %s and %s", cn, mn)
-	}
-
-	// Write database to disk before returning.
-	defer pState.DB.Write()
-
-	// Process all feeds.
-	processing := 0
-	for _, feed := range fConf.Feeds {
-		f := feed
-		go f.Process(pState)
-		processing += 1
-	}
-	// Wait for all feeds finish processing.
-	for processing > 0 {
-		fTxt := "feeds"
-		if processing == 1 {
-			fTxt = "feed"
+		n := len(args) - fs.NArg()
+		if fs.NArg() == 0 || (n > 0 && args[n-1] == "--") {
+			pos = append(pos, fs.Args()...)
+			break
 		}
-		fmt.Printf("Waiting for %d %s to finish processing\n",
-			processing, fTxt)
-		fr := <-pState.FeedResultChan
-		if fr.Err == nil {
-			fmt.Printf("[%s]: %s\n",
-				fr.FeedId, fr.FeedResult)
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(pos) < min || (max >= 0 && len(pos) > max) {
+		if len(pos) < min {
+			fmt.Fprintf(fs.Output(), "%s: too few arguments\n",
+				cmd.path())
 		} else {
-			fmt.Printf("[%s]: %s: %v\n",
-				fr.FeedId, fr.FeedResult, fr.Err.Error())
+			fmt.Fprintf(fs.Output(), "%s: unexpected argument '%s'\n",
+				cmd.path(), pos[max])
+		}
+		fs.Usage()
+		return nil, false, 2
+	}
+	return pos, true, 0
+}
+
+// Runs the command with `args`, the arguments that follow its name.
+//
+// Returns the code fern must exit with.
+func (cmd *command) execute(args []string) int {
+	if cmd.run != nil {
+		return cmd.run(cmd, args)
+	}
+
+	// Group of commands.
+	if len(args) == 0 {
+		cmd.usage(os.Stdout)
+		return 2
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		cmd.usage(os.Stdout)
+		return 0
+	}
+	c := cmd.lookup(args[0])
+	if c == nil {
+		fmt.Printf("%s: unknown command '%s'\n", cmd.path(), args[0])
+		fmt.Printf("Do 'fern help' for usage.\n")
+		return 2
+	}
+	return c.execute(args[1:])
+}
+
+var cmdHelp = &command{
+	name:  "help",
+	args:  "[command]",
+	short: "Show help for a command",
+	run:   runHelp,
+}
+
+func runHelp(cmd *command, args []string) int {
+	c := fern
+	for _, name := range args {
+		if c.run != nil {
+			break
 		}
-		processing -= 1
+		c = c.lookup(name)
+		if c == nil {
+			fmt.Printf("%s: unknown command '%s'\n", cmd.path(),
+				strings.Join(args, " "))
+			return 2
+		}
+	}
+	if c.run != nil {
+		return c.run(c, []string{"-h"})
+	}
+	c.usage(os.Stdout)
+	return 0
+}
+
+var cmdVersion = &command{
+	name:  "version",
+	short: "Print fern's version",
+	run:   runVersion,
+}
+
+func runVersion(cmd *command, args []string) int {
+	fs := cmd.flagSet()
+	if _, ok, code := cmd.parse(fs, args, 0, 0); !ok {
+		return code
+	}
+	fmt.Printf("%s\n", version.Version)
+	return 0
+}
+
+// Returns "1 `one`" if `n` is 1; "`n` `many`" otherwise.
+func entriesTxt(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// Translates the flags of older versions of fern, like `-run` and
+// `-version`, in `args` to the command that replaces them.
+func legacyArgs(args []string) []string {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "-") {
+		return args
+	}
+
+	// The flags of the run command tell which flags take a value.
+	fs := cmdRun.flagSet()
+	newRunFlags(fs)
+
+	cmd := ""
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			// A command, like in `fern -config fern.json run`.
+			return args
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "version":
+			return []string{cmdVersion.name}
+		case "run":
+			cmd = cmdRun.name
+			continue
+		case "retry-failed":
+			cmd = cmdRun.name
+		}
+		rest = append(rest, arg)
+		f := fs.Lookup(name)
+		if f == nil || hasValue || isBoolFlag(f) || i+1 == len(args) {
+			continue
+		}
+		// Value of the flag.
+		i += 1
+		rest = append(rest, args[i])
+	}
+	if len(cmd) == 0 {
+		return args
+	}
+	translated := append([]string{cmd}, rest...)
+	log.Printf("Warning: 'fern %s' is deprecated; use 'fern %s'",
+		strings.Join(args, " "), strings.Join(translated, " "))
+	return translated
+}
+
+// Returns true if the flag `f` takes no value, like a flag defined by
+// flag.Bool.
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+func main() {
+	args := legacyArgs(os.Args[1:])
+
+	// Parse the flags that come before the command.
+	fs := flag.NewFlagSet(fern.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() {
+		fern.usage(fs.Output())
+		fmt.Fprintf(fs.Output(), "\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	locationFlags(fs)
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(2)
 	}
+	os.Exit(fern.execute(fs.Args()))
 }
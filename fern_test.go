@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLegacyArgs(t *testing.T) {
+	tests := []struct {
+		args     string
+		expected string
+	}{
+		{"", ""},
+		{"run", "run"},
+		{"run -prof /tmp", "run -prof /tmp"},
+		{"-config fern.json run", "-config fern.json run"},
+		{"-config fern.json list", "-config fern.json list"},
+		{"-version", "version"},
+		{"-run", "run"},
+		{"--run", "run"},
+		{"-run -retry-failed", "run -retry-failed"},
+		{"-retry-failed", "run -retry-failed"},
+		{"-run -prof /tmp", "run -prof /tmp"},
+		{"-prof /tmp -run", "run -prof /tmp"},
+		{"-prof=/tmp -run", "run -prof=/tmp"},
+		{"-config fern.json -run -db db.json", "run -config fern.json -db db.json"},
+		{"-run -wait -tag music", "run -wait -tag music"},
+		{"-prof /tmp", "-prof /tmp"},
+		{"-run -- npr", "run -- npr"},
+	}
+	for _, test := range tests {
+		args := strings.Fields(test.args)
+		got := strings.Join(legacyArgs(args), " ")
+		if got != test.expected {
+			t.Errorf("legacyArgs(%s): '%s' != '%s'", test.args, got,
+				test.expected)
+			return
+		}
+	}
+}
@@ -0,0 +1,361 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Registers the schemas built into fern. YouTube's schema comes before
+// Atom's, so that Detect tells YouTube feeds from other Atom feeds.
+func init() {
+	Register("npr", ParserFunc(nprUnmarshal))
+	Register("youtube", youtubeParser{})
+	Register("podcast", ParserFunc(podcastUnmarshal))
+	Register("rss", rssParser{})
+	Register("atom", atomParser{})
+	Register("jsonfeed", jsonFeedParser{})
+}
+
+// XML namespaces of Atom and of YouTube's extensions to it.
+const (
+	atomNS    = "http://www.w3.org/2005/Atom"
+	youtubeNS = "http://www.youtube.com/xml/schemas/2015"
+)
+
+// Parses YouTube feeds; detects Atom feeds with YouTube's extensions.
+type youtubeParser struct{}
+
+func (youtubeParser) Parse(bs []byte) ([]Entry, error) {
+	return youtubeUnmarshal(bs)
+}
+
+func (youtubeParser) Detect(bs []byte) bool {
+	root, ok := xmlRoot(bs)
+	if !ok || root.Name.Local != "feed" || root.Name.Space != atomNS {
+		return false
+	}
+	for _, attr := range root.Attr {
+		if attr.Name.Space == "xmlns" && attr.Value == youtubeNS {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses RSS 2.0 feeds; detects feeds whose root element is rss.
+type rssParser struct{}
+
+func (rssParser) Parse(bs []byte) ([]Entry, error) {
+	return rssUnmarshal(bs)
+}
+
+func (rssParser) Detect(bs []byte) bool {
+	root, ok := xmlRoot(bs)
+	return ok && root.Name.Local == "rss"
+}
+
+// Parses Atom feeds; detects feeds whose root element is feed in the
+// Atom namespace.
+type atomParser struct{}
+
+func (atomParser) Parse(bs []byte) ([]Entry, error) {
+	return atomUnmarshal(bs)
+}
+
+func (atomParser) Detect(bs []byte) bool {
+	root, ok := xmlRoot(bs)
+	return ok && root.Name.Local == "feed" && root.Name.Space == atomNS
+}
+
+// Parses JSON Feeds; detects JSON documents whose version is a JSON
+// Feed version.
+type jsonFeedParser struct{}
+
+// Prefix of the version of JSON Feeds.
+const jsonFeedVersion = "https://jsonfeed.org/version/"
+
+func (jsonFeedParser) Parse(bs []byte) ([]Entry, error) {
+	return jsonFeedUnmarshal(bs)
+}
+
+func (jsonFeedParser) Detect(bs []byte) bool {
+	var jf struct {
+		Version string `json:"version"`
+	}
+	err := json.Unmarshal(bs, &jf)
+	return err == nil && strings.HasPrefix(jf.Version, jsonFeedVersion)
+}
+
+// Returns the root element of the XML document `bs` and true; false
+// if `bs` is not XML.
+func xmlRoot(bs []byte) (xml.StartElement, bool) {
+	d := xml.NewDecoder(bytes.NewReader(bs))
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, false
+		}
+		if root, ok := tok.(xml.StartElement); ok {
+			return root, true
+		}
+		// Skip prolog.
+	}
+}
+
+// Unmarshal a NPR feed.
+func nprUnmarshal(bs []byte) ([]Entry, error) {
+	nprFeed := new(NPRFeed)
+	err := xml.Unmarshal(bs, nprFeed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all entries.
+	entries := make([]Entry, 0)
+	for _, e := range nprFeed.Entries {
+		t, err := time.Parse(time.RFC1123Z, e.Pub)
+		if err != nil {
+			return nil, err
+		}
+		entry := Entry{
+			Id:      e.Id,
+			Title:   e.Title,
+			PubTime: t,
+			Link:    e.Link.Url,
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Unmarshal a YouTube feed.
+func youtubeUnmarshal(bs []byte) ([]Entry, error) {
+	ytFeed := new(YouTubeFeed)
+	err := xml.Unmarshal(bs, ytFeed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all entries.
+	entries := make([]Entry, 0)
+	for _, e := range ytFeed.Entries {
+		t, err := time.Parse(time.RFC3339, e.Pub)
+		if err != nil {
+			return nil, err
+		}
+		entry := Entry{
+			Id:      e.Id,
+			Title:   e.Title,
+			PubTime: t,
+			Link:    e.Link.Url,
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Unmarshal a Podcast feed.
+func podcastUnmarshal(bs []byte) ([]Entry, error) {
+	pcFeed := new(PodcastFeed)
+	err := xml.Unmarshal(bs, pcFeed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all entries.
+	entries := make([]Entry, 0)
+	for _, e := range pcFeed.Entries {
+		layout := time.RFC1123Z
+		if e.Pub[len(e.Pub)-1:] == "T" {
+			// Textual time zone. like 'EDT'.
+			if e.Pub[6:7] == " " {
+				layout = "Mon, 2 Jan 2006 15:04:05 MST"
+			} else {
+				layout = time.RFC1123
+			}
+		}
+		t, err := time.Parse(layout, e.Pub)
+		if err != nil {
+			return nil, err
+		}
+		// Enclosure length is optional and often bogus; treat
+		// it as unknown if it is not a number.
+		length, _ := strconv.ParseInt(e.Link.Length, 10, 64)
+		entry := Entry{
+			Id:      e.Id,
+			Title:   e.Title,
+			PubTime: t,
+			Link:    e.Link.Url,
+			Length:  length,
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Layouts of the publication times in RSS feeds, which are meant to
+// be RFC 822 but often are not quite.
+var rssTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+}
+
+// Parses the publication time `pub` of an entry in a RSS feed. An
+// empty `pub` is the zero time.
+func parseRSSTime(pub string) (time.Time, error) {
+	pub = strings.TrimSpace(pub)
+	if len(pub) == 0 {
+		return time.Time{}, nil
+	}
+	for _, layout := range rssTimeLayouts {
+		t, err := time.Parse(layout, pub)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("publication time '%s' invalid", pub)
+}
+
+// Unmarshal a RSS 2.0 feed. An entry's link is its enclosure's URL or,
+// if it has no enclosure, its link.
+func rssUnmarshal(bs []byte) ([]Entry, error) {
+	rssFeed := new(RSSFeed)
+	err := xml.Unmarshal(bs, rssFeed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all entries.
+	entries := make([]Entry, 0)
+	for _, e := range rssFeed.Entries {
+		t, err := parseRSSTime(e.Pub)
+		if err != nil {
+			return nil, err
+		}
+		entry := Entry{
+			Id:      strings.TrimSpace(e.Id),
+			Title:   strings.TrimSpace(e.Title),
+			PubTime: t,
+			Link:    strings.TrimSpace(e.Link),
+		}
+		if len(e.Enclosure.Url) > 0 {
+			entry.Link = e.Enclosure.Url
+			// Enclosure length is optional and often bogus;
+			// treat it as unknown if it is not a number.
+			entry.Length, _ = strconv.ParseInt(e.Enclosure.Length,
+				10, 64)
+		}
+		if len(entry.Id) == 0 {
+			// guid is optional.
+			entry.Id = entry.Link
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Unmarshal an Atom feed. An entry's link is its enclosure link's URL
+// or, if it has no enclosure link, its alternate link's URL.
+func atomUnmarshal(bs []byte) ([]Entry, error) {
+	atomFeed := new(AtomFeed)
+	err := xml.Unmarshal(bs, atomFeed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get all entries.
+	entries := make([]Entry, 0)
+	for _, e := range atomFeed.Entries {
+		pub := e.Published
+		if len(pub) == 0 {
+			pub = e.Updated
+		}
+		t := time.Time{}
+		if len(pub) > 0 {
+			t, err = time.Parse(time.RFC3339, strings.TrimSpace(pub))
+			if err != nil {
+				return nil, err
+			}
+		}
+		entry := Entry{
+			Id:      strings.TrimSpace(e.Id),
+			Title:   strings.TrimSpace(e.Title),
+			PubTime: t,
+		}
+		alternate := ""
+		for _, l := range e.Links {
+			switch {
+			case l.Rel == "enclosure" && len(entry.Link) == 0:
+				entry.Link = l.Href
+				entry.Length, _ = strconv.ParseInt(l.Length, 10, 64)
+			case (l.Rel == "alternate" || len(l.Rel) == 0) &&
+				len(alternate) == 0:
+				alternate = l.Href
+			}
+		}
+		if len(entry.Link) == 0 {
+			entry.Link = alternate
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Unmarshal a JSON Feed. An entry's link is its item's first
+// attachment's URL or, if it has no attachments, its URL. Items
+// without a title are titled by their summary.
+func jsonFeedUnmarshal(bs []byte) ([]Entry, error) {
+	jf := new(JSONFeed)
+	err := json.Unmarshal(bs, jf)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(jf.Version, jsonFeedVersion) {
+		return nil, fmt.Errorf("version '%s' is not a JSON Feed"+
+			" version", jf.Version)
+	}
+
+	// Get all entries.
+	entries := make([]Entry, 0)
+	for _, item := range jf.Items {
+		pub := item.Published
+		if len(pub) == 0 {
+			pub = item.Modified
+		}
+		t := time.Time{}
+		if len(pub) > 0 {
+			t, err = time.Parse(time.RFC3339, pub)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entry := Entry{
+			Id:      string(item.Id),
+			Title:   strings.TrimSpace(item.Title),
+			PubTime: t,
+			Link:    item.Url,
+		}
+		if len(entry.Title) == 0 {
+			entry.Title = strings.TrimSpace(item.Summary)
+		}
+		if len(item.Attachments) > 0 {
+			entry.Link = item.Attachments[0].Url
+			entry.Length = item.Attachments[0].Size
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package schema
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"ricketyspace.net/fern/file"
+)
+
+func TestPodcastUnmarshal(t *testing.T) {
+	testFeeds := []string{
+		"testdata/pc-atp.xml",
+		"testdata/pc-daringfireball.xml",
+		"testdata/pc-kara.xml",
+		"testdata/pc-scwpod.xml",
+	}
+	for _, feed := range testFeeds {
+		bs, err := file.ReadFile(feed)
+		if err != nil {
+			t.Errorf("read feed: %v", err)
+			return
+		}
+		entries, err := podcastUnmarshal(bs)
+		if err != nil {
+			t.Errorf("feed unmarshal: %v", err)
+			return
+		}
+		for _, entry := range entries {
+			if len(entry.Id) < 1 {
+				t.Errorf("entry id: %v", entry.Id)
+				return
+			}
+			if len(entry.Title) < 1 {
+				t.Errorf("entry title: %v", entry.Title)
+				return
+			}
+			if entry.PubTime.Unix() < 994702392 {
+				t.Errorf("entry time: %v", entry.PubTime)
+				return
+			}
+			_, err = url.Parse(entry.Link)
+			if err != nil {
+				t.Errorf("entry link: %s: %v", entry.Link, err)
+				return
+			}
+		}
+	}
+}
+
+func TestJSONFeedUnmarshal(t *testing.T) {
+	tests := []struct {
+		feed    string
+		entries []Entry
+	}{
+		{
+			"testdata/jf-podcast.json",
+			[]Entry{
+				{
+					Id:      "https://backroom.example.org/episodes/12",
+					Title:   "12: Tape Loops",
+					PubTime: time.Date(2024, 4, 2, 22, 0, 0, 0, time.UTC),
+					Link:    "https://media.example.org/backroom/12.mp3",
+					Length:  31457280,
+				},
+				{
+					Id:      "https://backroom.example.org/episodes/11",
+					Title:   "11: Night Shifts",
+					PubTime: time.Date(2024, 3, 19, 22, 0, 0, 0, time.UTC),
+					Link:    "https://media.example.org/backroom/11.mp3",
+				},
+			},
+		},
+		{
+			"testdata/jf-blog.json",
+			[]Entry{
+				{
+					Id:      "2041",
+					Title:   "Restoring a Lathe",
+					PubTime: time.Date(2024, 5, 11, 9, 12, 0, 0, time.UTC),
+					Link:    "https://workshop.example.net/2024/05/lathe",
+				},
+				{
+					Id:      "2040",
+					Title:   "A video tour of the new bench.",
+					PubTime: time.Date(2024, 4, 20, 16, 45, 0, 0, time.UTC),
+					Link:    "https://workshop.example.net/media/bench.mp4",
+					Length:  73400320,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		bs, err := file.ReadFile(test.feed)
+		if err != nil {
+			t.Errorf("read feed: %v", err)
+			return
+		}
+		entries, err := jsonFeedUnmarshal(bs)
+		if err != nil {
+			t.Errorf("%s: unmarshal: %v", test.feed, err)
+			return
+		}
+		if len(entries) != len(test.entries) {
+			t.Errorf("%s: entries: %d != %d", test.feed,
+				len(entries), len(test.entries))
+			return
+		}
+		for i, entry := range entries {
+			expected := test.entries[i]
+			if entry.Id != expected.Id || entry.Title != expected.Title ||
+				!entry.PubTime.Equal(expected.PubTime) ||
+				entry.Link != expected.Link ||
+				entry.Length != expected.Length {
+				t.Errorf("%s: entry %d: %+v != %+v", test.feed, i,
+					entry, expected)
+				return
+			}
+		}
+	}
+
+	// Not a JSON Feed.
+	_, err := jsonFeedUnmarshal([]byte(`{"version": "2", "items": []}`))
+	if err == nil {
+		t.Errorf("unmarshal: expected error for version '2'")
+		return
+	}
+}
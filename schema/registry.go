@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package schema
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Name of the schema that stands for the schema detected from the
+// feed; see Detect.
+const Auto = "auto"
+
+// Parses feeds of a schema into entries.
+type Parser interface {
+	// Parses the feed `bs`.
+	//
+	// Returns the entries in the feed; error if `bs` is not a
+	// feed of the parser's schema.
+	Parse(bs []byte) ([]Entry, error)
+}
+
+// Implemented by Parsers that can tell whether a feed is of their
+// schema; only those take part in Detect.
+type Detector interface {
+	// Returns true if the feed `bs` is of the parser's schema.
+	Detect(bs []byte) bool
+}
+
+// Adapts a function to the Parser interface.
+type ParserFunc func(bs []byte) ([]Entry, error)
+
+// Calls f(bs).
+func (f ParserFunc) Parse(bs []byte) ([]Entry, error) {
+	return f(bs)
+}
+
+// Registered parsers.
+var registry = struct {
+	mutex   sync.RWMutex
+	names   []string // In the order they were registered
+	parsers map[string]Parser
+}{
+	parsers: make(map[string]Parser),
+}
+
+// Registers `parser` for the schema `name`, so that feeds with that
+// schema in the config are parsed by it. Programs that embed fern
+// call Register in an init function to add schemas.
+//
+// Panics if `name` is empty or "auto", if `parser` is nil or if a
+// parser is already registered for `name`.
+func Register(name string, parser Parser) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if len(name) == 0 || name == Auto {
+		panic(fmt.Sprintf("schema: Register: invalid name '%s'", name))
+	}
+	if parser == nil {
+		panic(fmt.Sprintf("schema: Register: parser for '%s' is nil",
+			name))
+	}
+	if _, ok := registry.parsers[name]; ok {
+		panic(fmt.Sprintf("schema: Register: '%s' registered twice",
+			name))
+	}
+	registry.names = append(registry.names, name)
+	registry.parsers[name] = parser
+}
+
+// Returns the parser registered for the schema `name` and true; nil
+// and false if there is none.
+func Lookup(name string) (Parser, bool) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	parser, ok := registry.parsers[name]
+	return parser, ok
+}
+
+// Returns the names of the registered schemas, in the order they
+// were registered.
+func Names() []string {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	return append([]string{}, registry.names...)
+}
+
+// Detects the schema of the feed `bs` by asking the registered
+// parsers that are Detectors, in the order they were registered.
+//
+// Returns the name of the first schema whose parser detects the feed;
+// error if none does.
+func Detect(bs []byte) (string, error) {
+	registry.mutex.RLock()
+	defer registry.mutex.RUnlock()
+
+	for _, name := range registry.names {
+		d, ok := registry.parsers[name].(Detector)
+		if ok && d.Detect(bs) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unable to detect schema")
+}
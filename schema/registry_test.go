@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package schema
+
+import (
+	"slices"
+	"testing"
+
+	"ricketyspace.net/fern/file"
+)
+
+func TestRegister(t *testing.T) {
+	// Built-in schemas.
+	names := Names()
+	for _, name := range []string{"npr", "youtube", "podcast", "rss", "atom", "jsonfeed"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("names: '%s' not in %v", name, names)
+			return
+		}
+		if _, ok := Lookup(name); !ok {
+			t.Errorf("lookup: '%s' not found", name)
+			return
+		}
+	}
+	if _, ok := Lookup(Auto); ok {
+		t.Errorf("lookup: '%s' must not be registered", Auto)
+		return
+	}
+
+	// A schema registered by a program that embeds fern.
+	parser := ParserFunc(func(bs []byte) ([]Entry, error) {
+		return []Entry{{Id: string(bs)}}, nil
+	})
+	Register("test-echo", parser)
+	p, ok := Lookup("test-echo")
+	if !ok {
+		t.Errorf("lookup: 'test-echo' not found")
+		return
+	}
+	entries, err := p.Parse([]byte("42"))
+	if err != nil || len(entries) != 1 || entries[0].Id != "42" {
+		t.Errorf("parse: %v: %v", entries, err)
+		return
+	}
+	if Names()[len(Names())-1] != "test-echo" {
+		t.Errorf("names: 'test-echo' not last: %v", Names())
+		return
+	}
+
+	// Invalid registrations panic.
+	for _, test := range []struct {
+		name   string
+		parser Parser
+	}{
+		{"", parser},
+		{Auto, parser},
+		{"test-nil", nil},
+		{"test-echo", parser},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("register '%s': expected panic",
+						test.name)
+				}
+			}()
+			Register(test.name, test.parser)
+		}()
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		feed   string
+		schema string
+	}{
+		{"testdata/pc-scwpod.xml", "rss"},
+		{"testdata/rss-generic.xml", "rss"},
+		{"testdata/atom-generic.xml", "atom"},
+		{"testdata/yt-channel.xml", "youtube"},
+		{"testdata/jf-podcast.json", "jsonfeed"},
+		{"testdata/jf-blog.json", "jsonfeed"},
+	}
+	for _, test := range tests {
+		bs, err := file.ReadFile(test.feed)
+		if err != nil {
+			t.Errorf("read feed: %v", err)
+			return
+		}
+		s, err := Detect(bs)
+		if err != nil {
+			t.Errorf("detect: %s: %v", test.feed, err)
+			return
+		}
+		if s != test.schema {
+			t.Errorf("detect: %s: %s != %s", test.feed, s,
+				test.schema)
+			return
+		}
+	}
+
+	for _, doc := range []string{
+		"<html><body>Not a feed</body></html>",
+		"<feed><entry/></feed>", // Not in the Atom namespace
+		`{"version": "1.1", "items": []}`,
+		"",
+	} {
+		if s, err := Detect([]byte(doc)); err == nil {
+			t.Errorf("detect: '%s': expected error, got %s",
+				doc, s)
+			return
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package schema
 
 import (
+	"encoding/json"
 	"encoding/xml"
 	"strings"
 	"time"
@@ -15,6 +16,7 @@ type Entry struct {
 	Title   string
 	PubTime time.Time
 	Link    string
+	Length  int64 // Size of the media in bytes; 0 if unknown
 }
 
 // Represents a NPR media link.
@@ -65,6 +67,7 @@ type YouTubeFeed struct {
 type PodcastLink struct {
 	XMLName xml.Name `xml:"enclosure"`
 	Url     string   `xml:"url,attr"`
+	Length  string   `xml:"length,attr"` // Bytes; not always set or valid
 }
 
 // Represents an entry in the Podcast feed.
@@ -83,6 +86,97 @@ type PodcastFeed struct {
 	Entries []PodcastEntry `xml:"channel>item"`
 }
 
+// Represents an enclosure in a RSS feed.
+type RSSEnclosure struct {
+	XMLName xml.Name `xml:"enclosure"`
+	Url     string   `xml:"url,attr"`
+	Length  string   `xml:"length,attr"` // Bytes; not always set or valid
+}
+
+// Represents an entry in a RSS 2.0 feed.
+type RSSEntry struct {
+	XMLName   xml.Name     `xml:"item"`
+	Id        string       `xml:"guid"`
+	Title     string       `xml:"title"`
+	Pub       string       `xml:"pubDate"` // RFC1123Z, mostly
+	Link      string       `xml:"link"`
+	Enclosure RSSEnclosure `xml:"enclosure"`
+}
+
+// Represents a RSS 2.0 feed.
+type RSSFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Entries []RSSEntry `xml:"channel>item"`
+}
+
+// Represents a link in an Atom feed.
+type AtomLink struct {
+	XMLName xml.Name `xml:"link"`
+	Rel     string   `xml:"rel,attr"`
+	Href    string   `xml:"href,attr"`
+	Length  string   `xml:"length,attr"` // Bytes; not always set
+}
+
+// Represents an entry in an Atom feed.
+type AtomEntry struct {
+	XMLName   xml.Name   `xml:"entry"`
+	Id        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"` // RFC3339; optional
+	Updated   string     `xml:"updated"`   // RFC3339
+	Links     []AtomLink `xml:"link"`
+}
+
+// Represents an Atom feed.
+type AtomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Entries []AtomEntry `xml:"entry"`
+}
+
+// Represents an attachment of an item in a JSON Feed.
+type JSONFeedAttachment struct {
+	Url      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size_in_bytes"` // Optional
+}
+
+// Represents the id of an item in a JSON Feed. It is a string, but
+// JSON Feed 1.0 feeds sometimes have numbers.
+type JSONFeedId string
+
+func (id *JSONFeedId) UnmarshalJSON(bs []byte) error {
+	var n json.Number
+	err := json.Unmarshal(bs, &n)
+	if err == nil {
+		*id = JSONFeedId(n)
+		return nil
+	}
+	var s string
+	err = json.Unmarshal(bs, &s)
+	if err != nil {
+		return err
+	}
+	*id = JSONFeedId(s)
+	return nil
+}
+
+// Represents an item in a JSON Feed.
+type JSONFeedItem struct {
+	Id          JSONFeedId           `json:"id"`
+	Url         string               `json:"url"`
+	Title       string               `json:"title"`          // Optional
+	Summary     string               `json:"summary"`        // Optional
+	Published   string               `json:"date_published"` // RFC3339; optional
+	Modified    string               `json:"date_modified"`  // RFC3339; optional
+	Attachments []JSONFeedAttachment `json:"attachments"`
+}
+
+// Represents a JSON Feed; version 1 or 1.1.
+type JSONFeed struct {
+	Version string         `json:"version"`
+	Items   []JSONFeedItem `json:"items"`
+}
+
 func (e Entry) TitleContains(contains string) bool {
 	return strings.Contains(strings.ToLower(e.Title), strings.ToLower(contains))
 }
@@ -66,3 +66,71 @@ func TestPodcastFeed(t *testing.T) {
 		}
 	}
 }
+
+func TestRSSFeed(t *testing.T) {
+	bs, err := file.ReadFile("testdata/rss-generic.xml")
+	if err != nil {
+		t.Errorf("read feed: %v", err)
+		return
+	}
+	rf := new(RSSFeed)
+	err = xml.Unmarshal(bs, rf)
+	if err != nil {
+		t.Errorf("xml unmarshal: %v", err)
+		return
+	}
+	if len(rf.Entries) != 3 {
+		t.Errorf("entries: %d != 3", len(rf.Entries))
+		return
+	}
+	e := rf.Entries[0]
+	if e.Id != "fr-003" || e.Title != "Rain on a Tin Roof" ||
+		e.Link != "https://example.com/rain" ||
+		e.Enclosure.Url != "https://cdn.example.com/fr/rain.ogg" ||
+		e.Enclosure.Length != "4194304" {
+		t.Errorf("entry: %+v", e)
+		return
+	}
+	if len(rf.Entries[2].Enclosure.Url) != 0 {
+		t.Errorf("entry without enclosure: %+v", rf.Entries[2])
+		return
+	}
+}
+
+func TestAtomFeed(t *testing.T) {
+	for _, feed := range []string{
+		"testdata/atom-generic.xml",
+		"testdata/yt-channel.xml",
+	} {
+		bs, err := file.ReadFile(feed)
+		if err != nil {
+			t.Errorf("read feed: %v", err)
+			return
+		}
+		af := new(AtomFeed)
+		err = xml.Unmarshal(bs, af)
+		if err != nil {
+			t.Errorf("xml unmarshal: %v", err)
+			return
+		}
+		if len(af.Entries) < 1 {
+			t.Errorf("%s: no entries", feed)
+			return
+		}
+		for _, entry := range af.Entries {
+			if len(entry.Id) < 1 || len(entry.Title) < 1 {
+				t.Errorf("%s: entry: %+v", feed, entry)
+				return
+			}
+			if len(entry.Links) < 1 {
+				t.Errorf("%s: entry links: %+v", feed, entry)
+				return
+			}
+			_, err := time.Parse(time.RFC3339, entry.Updated)
+			if err != nil {
+				t.Errorf("%s: entry updated: %v", feed, err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package state
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Limits on the number of things fern does at once. A limit of 0 or
+// less is no limit.
+type Limits struct {
+	MaxDownloads        int            // Downloads at once
+	MaxDownloadsPerHost int            // Downloads at once from a host
+	HostLimits          map[string]int // Downloads at once from a host and its subdomains; overrides MaxDownloadsPerHost
+	MaxFeeds            int            // Feeds fetched at once
+}
+
+// Limits the number of downloads, overall and per host, and of feed
+// fetches that run at once; shared between the go routines that
+// process the feeds.
+type Limiter struct {
+	limits    Limits
+	downloads chan struct{} // Semaphore for downloads; nil if no limit
+	feeds     chan struct{} // Semaphore for feed fetches; nil if no limit
+	mutex     *sync.Mutex
+	hosts     map[string]chan struct{} // Semaphores for hosts
+}
+
+// Creates a Limiter that enforces `limits` and returns a pointer to
+// it.
+func NewLimiter(limits Limits) *Limiter {
+	l := new(Limiter)
+	l.limits = limits
+	if limits.MaxDownloads > 0 {
+		l.downloads = make(chan struct{}, limits.MaxDownloads)
+	}
+	if limits.MaxFeeds > 0 {
+		l.feeds = make(chan struct{}, limits.MaxFeeds)
+	}
+	l.mutex = new(sync.Mutex)
+	l.hosts = make(map[string]chan struct{})
+	return l
+}
+
+// Waits until a download from the URL `link` may start, unless `done`
+// is closed before that.
+//
+// Returns a function that must be called once the download finishes
+// and true, if the download may start; nil and false if `done` was
+// closed.
+func (l *Limiter) AcquireDownload(link string,
+	done <-chan struct{}) (func(), bool) {
+	// Take the host's token first, so that downloads waiting for a
+	// busy host do not hold up downloads from other hosts.
+	host := l.host(link)
+	if !acquire(host, done) {
+		return nil, false
+	}
+	if !acquire(l.downloads, done) {
+		release(host)
+		return nil, false
+	}
+	return func() {
+		release(l.downloads)
+		release(host)
+	}, true
+}
+
+// Waits until a feed may be fetched, unless `done` is closed before
+// that.
+//
+// Returns a function that must be called once the fetch finishes and
+// true, if the fetch may start; nil and false if `done` was closed.
+func (l *Limiter) AcquireFeed(done <-chan struct{}) (func(), bool) {
+	if !acquire(l.feeds, done) {
+		return nil, false
+	}
+	return func() { release(l.feeds) }, true
+}
+
+// Returns the semaphore for the host in the URL `link`; nil if
+// downloads from the host are not limited.
+//
+// Hosts that match a key in HostLimits, either exactly or as a
+// subdomain, share the key's semaphore; the longest matching key
+// wins. Other hosts get a semaphore of their own if
+// MaxDownloadsPerHost is set.
+func (l *Limiter) host(link string) chan struct{} {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	key, limit := host, l.limits.MaxDownloadsPerHost
+	match := ""
+	for k, n := range l.limits.HostLimits {
+		k = strings.ToLower(k)
+		if host != k && !strings.HasSuffix(host, "."+k) {
+			continue
+		}
+		if len(k) > len(match) {
+			match = k
+			key, limit = k, n
+		}
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	sema, ok := l.hosts[key]
+	if !ok {
+		sema = make(chan struct{}, limit)
+		l.hosts[key] = sema
+	}
+	return sema
+}
+
+// Takes a token from the semaphore `sema`, waiting for one to be
+// available, unless `done` is closed before that. A nil `sema` has
+// tokens for all.
+//
+// Returns true if a token was taken.
+func acquire(sema chan struct{}, done <-chan struct{}) bool {
+	if sema == nil {
+		return true
+	}
+	// Take a free token even if `done` is closed.
+	select {
+	case sema <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case sema <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// Gives up a token taken from the semaphore `sema`.
+func release(sema chan struct{}) {
+	if sema != nil {
+		<-sema
+	}
+}
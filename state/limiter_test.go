@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: ISC
+// Copyright © 2022 siddharth <s@ricketyspace.net>
+
+package state
+
+import (
+	"testing"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(Limits{
+		MaxDownloads:        3,
+		MaxDownloadsPerHost: 2,
+		HostLimits:          map[string]int{"youtube.com": 1},
+		MaxFeeds:            1,
+	})
+	done := make(chan struct{})
+	close(done)
+
+	// Returns true if a download from `link` may start right away.
+	acquired := []func(){}
+	download := func(link string) bool {
+		release, ok := l.AcquireDownload(link, done)
+		if ok {
+			acquired = append(acquired, release)
+		}
+		return ok
+	}
+
+	// youtube.com and its subdomains share 1 slot.
+	if !download("https://www.youtube.com/watch?v=1") {
+		t.Errorf("youtube: expected a slot")
+		return
+	}
+	if download("https://youtube.com/watch?v=2") {
+		t.Errorf("youtube: expected no slot")
+		return
+	}
+
+	// Other hosts get 2 slots each, up to 3 downloads in all.
+	if !download("https://a.example.com/1.mp3") {
+		t.Errorf("a.example.com: expected a slot")
+		return
+	}
+	if !download("https://a.example.com/2.mp3") {
+		t.Errorf("a.example.com: expected a second slot")
+		return
+	}
+	if download("https://a.example.com/3.mp3") {
+		t.Errorf("a.example.com: expected no third slot")
+		return
+	}
+	if download("https://b.example.com/1.mp3") {
+		t.Errorf("b.example.com: expected no slot beyond 3 downloads")
+		return
+	}
+
+	// Releasing a download frees its slots.
+	acquired[0]()
+	if !download("https://b.example.com/1.mp3") {
+		t.Errorf("b.example.com: expected a slot after release")
+		return
+	}
+
+	// Feeds.
+	release, ok := l.AcquireFeed(done)
+	if !ok {
+		t.Errorf("feed: expected a slot")
+		return
+	}
+	if _, ok := l.AcquireFeed(done); ok {
+		t.Errorf("feed: expected no second slot")
+		return
+	}
+	release()
+	if _, ok := l.AcquireFeed(done); !ok {
+		t.Errorf("feed: expected a slot after release")
+		return
+	}
+
+	// No limits.
+	l = NewLimiter(Limits{})
+	for i := 0; i < 100; i++ {
+		if _, ok := l.AcquireDownload("https://example.com", done); !ok {
+			t.Errorf("no limits: expected a slot for download %d", i)
+			return
+		}
+	}
+}
@@ -3,7 +3,11 @@
 
 package state
 
-import "ricketyspace.net/fern/db"
+import (
+	"sync"
+
+	"ricketyspace.net/fern/db"
+)
 
 // Contains the result of processing a Feed.
 type FeedResult struct {
@@ -14,9 +18,11 @@ type FeedResult struct {
 
 // Contains the result of processing an Entry.
 type EntryResult struct {
-	EntryId    string // Entry's identifier
-	EntryTitle string // Entry's title
-	Err        error  // Set on error
+	EntryId    string    // Entry's identifier
+	EntryTitle string    // Entry's title
+	Record     db.Record // Record of the download; set on success
+	Attempts   int       // Number of attempts made to download the entry
+	Err        error     // Set on error
 }
 
 // Paraphernalia passed and shared between go routines that process
@@ -28,11 +34,39 @@ type ProcessState struct {
 	// caller about the number of entries that are being
 	// downloaded for a feed.
 	FeedResultChan chan FeedResult
+	// Closed when fern is asked to shut down; no new downloads
+	// are started after that.
+	Shutdown chan struct{}
+	// If true, entries that are quarantined after failing to
+	// download repeatedly are tried again.
+	RetryFailed bool
+	// Limits the downloads and feed fetches that run at once.
+	Limiter *Limiter
+	// For closing Shutdown only once.
+	shutdownOnce *sync.Once
 }
 
 // Creates an instance of ProcessState and returns a pointer to it.
 func NewProcessState() *ProcessState {
 	ps := new(ProcessState)
 	ps.FeedResultChan = make(chan FeedResult)
+	ps.Shutdown = make(chan struct{})
+	ps.Limiter = NewLimiter(Limits{})
+	ps.shutdownOnce = new(sync.Once)
 	return ps
 }
+
+// Tells the Feed.Process goroutines to not start new downloads.
+func (ps *ProcessState) StartShutdown() {
+	ps.shutdownOnce.Do(func() { close(ps.Shutdown) })
+}
+
+// Returns true if fern is shutting down.
+func (ps *ProcessState) ShuttingDown() bool {
+	select {
+	case <-ps.Shutdown:
+		return true
+	default:
+		return false
+	}
+}